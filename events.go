@@ -0,0 +1,146 @@
+package geo
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/comfforts/logger"
+
+	"github.com/comfforts/comff-geo-client/internal/loadbalance"
+)
+
+// ClusterEvent is a geo cluster leader or membership change.
+type ClusterEvent = loadbalance.Event
+
+// ClusterEventType is the kind of ClusterEvent.
+type ClusterEventType = loadbalance.EventType
+
+const (
+	LeaderElected   = loadbalance.LeaderElected
+	LeaderLost      = loadbalance.LeaderLost
+	FollowerAdded   = loadbalance.FollowerAdded
+	FollowerRemoved = loadbalance.FollowerRemoved
+)
+
+const eventBufferSize = 16
+
+// eventHub fans out cluster events to the Watch subscribers.
+type eventHub struct {
+	logger.AppLogger
+	mu     sync.Mutex
+	subs   map[chan ClusterEvent]struct{}
+	closed bool
+	// done is closed with the hub, ending the subscriptions.
+	done chan struct{}
+	// leader and followers are the current membership, replayed to the
+	// new subscribers.
+	leader    string
+	followers []string
+}
+
+func newEventHub(l logger.AppLogger) *eventHub {
+	return &eventHub{
+		AppLogger: l,
+		subs:      map[chan ClusterEvent]struct{}{},
+		done:      make(chan struct{}),
+	}
+}
+
+// publish sends the event to every subscriber, dropping it for
+// subscribers that aren't keeping up.
+func (h *eventHub) publish(event ClusterEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.Info("geo cluster event", zap.Stringer("type", event.Type), zap.String("addr", event.Addr))
+	h.apply(event)
+	for ch := range h.subs {
+		select {
+		case ch <- event:
+		default:
+			h.Warn("dropping geo cluster event, slow subscriber", zap.Stringer("type", event.Type), zap.String("addr", event.Addr))
+		}
+	}
+}
+
+// apply updates the current membership with the event.
+func (h *eventHub) apply(event ClusterEvent) {
+	switch event.Type {
+	case LeaderElected:
+		h.leader = event.Addr
+	case LeaderLost:
+		if h.leader == event.Addr {
+			h.leader = ""
+		}
+	case FollowerAdded:
+		h.followers = append(h.followers, event.Addr)
+	case FollowerRemoved:
+		for i, addr := range h.followers {
+			if addr == event.Addr {
+				h.followers = append(h.followers[:i], h.followers[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// subscribe replays the current membership to the new subscriber, the
+// first events are published before any, then sends the changes.
+func (h *eventHub) subscribe(ctx context.Context) <-chan ClusterEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		ch := make(chan ClusterEvent)
+		close(ch)
+		return ch
+	}
+	var members []ClusterEvent
+	if h.leader != "" {
+		members = append(members, ClusterEvent{Type: LeaderElected, Addr: h.leader})
+	}
+	for _, addr := range h.followers {
+		members = append(members, ClusterEvent{Type: FollowerAdded, Addr: addr})
+	}
+	ch := make(chan ClusterEvent, eventBufferSize+len(members))
+	for _, event := range members {
+		ch <- event
+	}
+	h.subs[ch] = struct{}{}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			h.unsubscribe(ch)
+		case <-h.done:
+		}
+	}()
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan ClusterEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+	close(h.done)
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
@@ -2,16 +2,21 @@ package geo
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"net"
+	"net/url"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
 
-	config "github.com/comfforts/comff-config"
 	api "github.com/comfforts/comff-geo/api/v1"
 	"github.com/comfforts/logger"
 
@@ -21,6 +26,10 @@ import (
 const DEFAULT_SERVICE_PORT = "62051"
 const DEFAULT_SERVICE_HOST = "127.0.0.1"
 
+// ErrSeedsWithTarget is returned for seeds given with an Addr target
+// other than geo-cqrs://, that the seeds can't be added to.
+var ErrSeedsWithTarget = errors.New("seeds need a geo-cqrs target")
+
 type ContextKey string
 
 func (c ContextKey) String() string {
@@ -28,19 +37,95 @@ func (c ContextKey) String() string {
 }
 
 var (
-	defaultDialTimeout      = 5 * time.Second
-	defaultKeepAlive        = 30 * time.Second
-	defaultKeepAliveTimeout = 10 * time.Second
+	defaultDialTimeout         = 5 * time.Second
+	defaultCallTimeout         = 5 * time.Second
+	defaultRouteTimeout        = 30 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+	defaultKeepAliveTimeout    = 10 * time.Second
+	defaultPermitWithoutStream = false
+	defaultRefreshInterval     = 30 * time.Second
+	defaultRefreshJitter       = 5 * time.Second
 )
 
 const GeoClientContextKey = ContextKey("geo-client")
 const DefaultClientName = "comfforts-geo-client"
 
 type ClientOption struct {
-	DialTimeout      time.Duration
+	// DialTimeout bounds each server dial, the TLS handshake gets at least
+	// this long. The reconnect backoff keeps the gRPC defaults,
+	// grpc.WithConnectParams in DialOptions changes it.
+	DialTimeout time.Duration
+	// CallTimeout is the default deadline for each RPC, zero for none.
+	// NewClient uses the default deadlines when it and MethodTimeouts
+	// are both unset, set MethodTimeouts to an empty map for no deadlines.
+	CallTimeout time.Duration
+	// MethodTimeouts override CallTimeout per RPC method name, e.g. GetGeoRoute.
+	MethodTimeouts map[string]time.Duration
+	// KeepAlive is the client ping interval, zero disables keepalive. The
+	// server's keepalive.EnforcementPolicy MinTime must not exceed it, gRPC-Go
+	// servers default to 5m and close faster pinging clients with GOAWAY
+	// too_many_pings.
 	KeepAlive        time.Duration
 	KeepAliveTimeout time.Duration
-	Caller           string
+	// PermitWithoutStream sends keepalive pings on idle connections, the
+	// server's enforcement policy must also set PermitWithoutStream.
+	PermitWithoutStream bool
+	Caller              string
+	// RefreshInterval is the period of the background cluster membership
+	// refresh, zero disables it. RefreshJitter adds up to this random delay.
+	RefreshInterval time.Duration
+	RefreshJitter   time.Duration
+	// MembershipCacheFile, when set, persists the last good cluster
+	// membership, used when the seed server is unavailable on start.
+	MembershipCacheFile string
+	// Addr is the geo service host:port, a comma separated seed list,
+	// or a full target with scheme.
+	Addr string
+	// Seeds are added to the seed servers in Addr, which must then be a
+	// seed list or a geo-cqrs:// target.
+	Seeds []string
+	// Discovery replaces the GetServers cluster membership source.
+	Discovery DiscoveryBuilder
+	// ReadYourWritesWindow, when set, pins the client reads to the leader
+	// for this window after each write. See Session for per context sessions.
+	ReadYourWritesWindow time.Duration
+	// BalancerConfig is the geo-cqrs balancer config JSON, e.g.
+	// {"followerSelection":"random","commonRoute":"leader"}
+	BalancerConfig string
+	// Retry, when set, retries the reads failing with a retryable status.
+	Retry *RetryPolicy
+	// HedgeDelays, keyed on the read RPC name, e.g. GeoLocate, sends the
+	// read again to another follower when the first hasn't answered
+	// within the delay, using the first answer.
+	HedgeDelays map[string]time.Duration
+	// HealthCheck enables the grpc.health.v1 checks of the cluster servers
+	// for HealthCheckService, the overall server health when empty.
+	// Unhealthy servers get no calls.
+	HealthCheck        bool
+	HealthCheckService string
+	// LeaderWait, when set, retries the writes failing with no leader
+	// for up to this time, while a new leader is elected.
+	LeaderWait time.Duration
+	// Locality is the client zone and region, reads prefer the followers
+	// in the same zone, then region.
+	Locality Locality
+	// ServerLocalities assigns the servers zone and region, keyed on
+	// their address, when discovery doesn't provide them.
+	ServerLocalities map[string]Locality
+	// Logger defaults to the global zap logger when not set.
+	Logger logger.AppLogger
+	// DiagnosticsLogger logs the resolver and balancer diagnostics, the
+	// picks at debug level. Defaults to the global zap logger.
+	DiagnosticsLogger *zap.Logger
+	// OnPick, when set, is called with every pick decision. It must not block.
+	OnPick func(PickTrace)
+	// TLS configures certificate verification and mTLS.
+	TLS *TLSOption
+	// Creds replaces the TLS setup entirely when set.
+	Creds       credentials.TransportCredentials
+	DialOptions []grpc.DialOption
+	// CallOptions are applied to every RPC, before the per-call options.
+	CallOptions []grpc.CallOption
 }
 
 type Client interface {
@@ -58,14 +143,26 @@ type Client interface {
 	GetAddressesByIds(ctx context.Context, req *api.GetAddressesRequest, opts ...grpc.CallOption) (*api.AddressesResponse, error)
 	DeleteAddress(ctx context.Context, req *api.DeleteAddressRequest, opts ...grpc.CallOption) (*api.DeleteResponse, error)
 	GetServers(ctx context.Context, req *api.GetServersRequest, opts ...grpc.CallOption) (*api.GetServersResponse, error)
+	// Watch returns the cluster leader and membership changes, until ctx
+	// is done or the client is closed. The current leader and followers
+	// come first, as LeaderElected and FollowerAdded events.
+	Watch(ctx context.Context) <-chan ClusterEvent
 	Close() error
 }
 
 func NewDefaultClientOption() *ClientOption {
 	return &ClientOption{
-		DialTimeout:      defaultDialTimeout,
-		KeepAlive:        defaultKeepAlive,
-		KeepAliveTimeout: defaultKeepAliveTimeout,
+		DialTimeout: defaultDialTimeout,
+		CallTimeout: defaultCallTimeout,
+		MethodTimeouts: map[string]time.Duration{
+			"GetGeoRoute":     defaultRouteTimeout,
+			"GetAddressRoute": defaultRouteTimeout,
+		},
+		KeepAlive:           defaultKeepAlive,
+		KeepAliveTimeout:    defaultKeepAliveTimeout,
+		PermitWithoutStream: defaultPermitWithoutStream,
+		RefreshInterval:     defaultRefreshInterval,
+		RefreshJitter:       defaultRefreshJitter,
 	}
 }
 
@@ -74,41 +171,146 @@ type geoClient struct {
 	client api.GeoClient
 	conn   *grpc.ClientConn
 	opts   *ClientOption
+	events *eventHub
+	sess   *Session
 }
 
+// NewClient creates a geo client for the given options, reading the
+// service address from GEO_SERVICE_HOST/GEO_SERVICE_PORT when not set.
 func NewClient(l logger.AppLogger, clientOpts *ClientOption) (*geoClient, error) {
+	clientOpts.Logger = l
+	if clientOpts.Addr == "" {
+		WithEnv()(clientOpts)
+	}
+	// options not built by NewDefaultClientOption keep the call deadlines
+	if clientOpts.CallTimeout == 0 && clientOpts.MethodTimeouts == nil {
+		defaults := NewDefaultClientOption()
+		clientOpts.CallTimeout = defaults.CallTimeout
+		clientOpts.MethodTimeouts = defaults.MethodTimeouts
+	}
+	return newClient(clientOpts)
+}
+
+// New creates a geo client configured by the given options.
+func New(opts ...Option) (*geoClient, error) {
+	clientOpts := NewDefaultClientOption()
+	for _, opt := range opts {
+		opt(clientOpts)
+	}
+	return newClient(clientOpts)
+}
+
+func newClient(clientOpts *ClientOption) (*geoClient, error) {
 	if clientOpts.Caller == "" {
 		clientOpts.Caller = DefaultClientName
 	}
+	l := clientOpts.Logger
+	if l == nil {
+		l = zap.L()
+	}
 
-	servicePort := os.Getenv("GEO_SERVICE_PORT")
-	if servicePort == "" {
-		servicePort = DEFAULT_SERVICE_PORT
+	serviceAddr := clientOpts.Addr
+	var seeds []string
+	if strings.Contains(serviceAddr, "://") && len(clientOpts.Seeds) > 0 {
+		target, err := url.Parse(serviceAddr)
+		if err != nil {
+			l.Error("invalid geo client target", zap.Error(err), zap.String("client", clientOpts.Caller))
+			return nil, err
+		}
+		if target.Scheme != loadbalance.GeoCQRSResolverName {
+			l.Error("geo client seeds given with a target", zap.String("target", serviceAddr), zap.String("client", clientOpts.Caller))
+			return nil, fmt.Errorf("%w: %s", ErrSeedsWithTarget, serviceAddr)
+		}
+		seeds = append(loadbalance.ParseSeeds(target.Host), clientOpts.Seeds...)
+		target.Host = strings.Join(seeds, ",")
+		serviceAddr = target.String()
 	}
-	serviceHost := os.Getenv("GEO_SERVICE_HOST")
-	if serviceHost == "" {
-		serviceHost = DEFAULT_SERVICE_HOST
+	if !strings.Contains(serviceAddr, "://") {
+		seeds = append(loadbalance.ParseSeeds(serviceAddr), clientOpts.Seeds...)
+		if len(seeds) == 0 {
+			seeds = []string{fmt.Sprintf("%s:%s", DEFAULT_SERVICE_HOST, DEFAULT_SERVICE_PORT)}
+		}
+		serviceAddr = strings.Join(seeds, ",")
+		l.Info("geo client serviceAddr", zap.String("serviceAddr", serviceAddr))
+		// with load balancer
+		serviceAddr = fmt.Sprintf("%s://%s", loadbalance.GeoCQRSResolverName, serviceAddr)
 	}
-
-	serviceAddr := fmt.Sprintf("%s:%s", serviceHost, servicePort)
-	l.Info("geo client serviceAddr", zap.String("serviceAddr", serviceAddr))
-	// with load balancer
-	serviceAddr = fmt.Sprintf("%s://%s", loadbalance.GeoCQRSResolverName, serviceAddr)
 	l.Info("geo client serviceAddr", zap.String("serviceAddr", serviceAddr))
 
-	tlsConfig, err := config.SetupTLSConfig(&config.ConfigOpts{
-		Target: config.GEO_CLIENT,
-		Addr:   serviceAddr,
-	})
+	creds := clientOpts.Creds
+	if creds == nil {
+		tlsConfig, err := setupTLSConfig(serviceAddr, clientOpts.TLS)
+		if err != nil {
+			l.Error("error setting geo client TLS", zap.Error(err), zap.String("client", clientOpts.Caller))
+			return nil, err
+		}
+		if tlsConfig.InsecureSkipVerify {
+			l.Warn("geo client skipping server certificate verification", zap.String("client", clientOpts.Caller))
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	if clientOpts.Retry != nil {
+		retry, err := clientOpts.Retry.withDefaults()
+		if err != nil {
+			l.Error("invalid geo client retry policy", zap.Error(err), zap.String("client", clientOpts.Caller))
+			return nil, err
+		}
+		clientOpts.Retry = retry
+	}
+	if err := clientOpts.validateHedging(); err != nil {
+		l.Error("invalid geo client hedging", zap.Error(err), zap.String("client", clientOpts.Caller))
+		return nil, err
+	}
+	balancerConfig, err := clientOpts.balancerConfig()
 	if err != nil {
-		l.Error("error setting geo client TLS", zap.Error(err), zap.String("client", clientOpts.Caller))
+		l.Error("invalid geo client balancer config", zap.Error(err), zap.String("client", clientOpts.Caller))
 		return nil, err
 	}
-	tlsConfig.InsecureSkipVerify = true
-	tlsCreds := credentials.NewTLS(tlsConfig)
+
+	events := newEventHub(l)
+
+	// options shared by the client and resolver connections
+	var connOpts []grpc.DialOption
+	if clientOpts.DialTimeout > 0 {
+		dialer := &net.Dialer{Timeout: clientOpts.DialTimeout}
+		connOpts = append(connOpts,
+			grpc.WithConnectParams(grpc.ConnectParams{
+				Backoff:           backoff.DefaultConfig,
+				MinConnectTimeout: clientOpts.DialTimeout,
+			}),
+			grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "tcp", addr)
+			}),
+		)
+	}
+	if clientOpts.KeepAlive > 0 {
+		connOpts = append(connOpts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                clientOpts.KeepAlive,
+			Timeout:             clientOpts.KeepAliveTimeout,
+			PermitWithoutStream: clientOpts.PermitWithoutStream,
+		}))
+	}
 	opts := []grpc.DialOption{
-		grpc.WithTransportCredentials(tlsCreds),
+		grpc.WithTransportCredentials(creds),
+		grpc.WithResolvers(&loadbalance.Resolver{
+			Discovery:          clientOpts.Discovery,
+			BalancerConfig:     balancerConfig,
+			DialOptions:        connOpts,
+			RefreshInterval:    clientOpts.RefreshInterval,
+			RefreshJitter:      clientOpts.RefreshJitter,
+			ResolveTimeout:     clientOpts.callTimeout("GetServers"),
+			CacheFile:          clientOpts.MembershipCacheFile,
+			OnEvent:            events.publish,
+			Localities:         clientOpts.ServerLocalities,
+			HealthCheck:        clientOpts.HealthCheck,
+			HealthCheckService: clientOpts.HealthCheckService,
+			Logger:             clientOpts.DiagnosticsLogger,
+			OnPick:             clientOpts.OnPick,
+		}),
+		grpc.WithChainUnaryInterceptor(routeInterceptor),
 	}
+	opts = append(opts, connOpts...)
+	opts = append(opts, clientOpts.DialOptions...)
 
 	conn, err := grpc.Dial(serviceAddr, opts...)
 	if err != nil {
@@ -116,21 +318,30 @@ func NewClient(l logger.AppLogger, clientOpts *ClientOption) (*geoClient, error)
 		return nil, err
 	}
 
+	var sess *Session
+	if clientOpts.ReadYourWritesWindow > 0 {
+		sess = NewSession(clientOpts.ReadYourWritesWindow)
+	}
+
 	client := api.NewGeoClient(conn)
-	l.Info("geo client connected", zap.String("host", serviceHost), zap.String("port", servicePort))
+	l.Info("geo client connected", zap.String("serviceAddr", serviceAddr))
 	return &geoClient{
 		client:    client,
 		AppLogger: l,
 		conn:      conn,
 		opts:      clientOpts,
+		events:    events,
+		sess:      sess,
 	}, nil
 }
 
 func (gc *geoClient) GeoLocate(ctx context.Context, req *api.GeoRequest, opts ...grpc.CallOption) (*api.GeoResponse, error) {
-	ctx, cancel := gc.contextWithOptions(ctx, gc.opts)
+	ctx, cancel := gc.contextWithOptions(ctx, "GeoLocate")
 	defer cancel()
 
-	resp, err := gc.client.GeoLocate(ctx, req)
+	resp, err := read(ctx, gc, "GeoLocate", opts, func(ctx context.Context) (*api.GeoResponse, error) {
+		return gc.client.GeoLocate(ctx, req, gc.callOptions(opts)...)
+	})
 	if err != nil {
 		gc.Error("error geo locating", zap.Error(err), zap.String("client", gc.opts.Caller))
 		return nil, err
@@ -139,10 +350,12 @@ func (gc *geoClient) GeoLocate(ctx context.Context, req *api.GeoRequest, opts ..
 }
 
 func (gc *geoClient) GetGeoRoute(ctx context.Context, req *api.GeoRouteRequest, opts ...grpc.CallOption) (*api.RouteResponse, error) {
-	ctx, cancel := gc.contextWithOptions(ctx, gc.opts)
+	ctx, cancel := gc.contextWithOptions(ctx, "GetGeoRoute")
 	defer cancel()
 
-	resp, err := gc.client.GetGeoRoute(ctx, req)
+	resp, err := read(ctx, gc, "GetGeoRoute", opts, func(ctx context.Context) (*api.RouteResponse, error) {
+		return gc.client.GetGeoRoute(ctx, req, gc.callOptions(opts)...)
+	})
 	if err != nil {
 		gc.Error("error fetching routes", zap.Error(err), zap.String("client", gc.opts.Caller))
 		return nil, err
@@ -151,10 +364,12 @@ func (gc *geoClient) GetGeoRoute(ctx context.Context, req *api.GeoRouteRequest,
 }
 
 func (gc *geoClient) GetAddressRoute(ctx context.Context, req *api.AddressRouteRequest, opts ...grpc.CallOption) (*api.RouteResponse, error) {
-	ctx, cancel := gc.contextWithOptions(ctx, gc.opts)
+	ctx, cancel := gc.contextWithOptions(ctx, "GetAddressRoute")
 	defer cancel()
 
-	resp, err := gc.client.GetAddressRoute(ctx, req)
+	resp, err := read(ctx, gc, "GetAddressRoute", opts, func(ctx context.Context) (*api.RouteResponse, error) {
+		return gc.client.GetAddressRoute(ctx, req, gc.callOptions(opts)...)
+	})
 	if err != nil {
 		gc.Error("error fetching routes", zap.Error(err), zap.String("client", gc.opts.Caller))
 		return nil, err
@@ -163,10 +378,13 @@ func (gc *geoClient) GetAddressRoute(ctx context.Context, req *api.AddressRouteR
 }
 
 func (gc *geoClient) AddGeo(ctx context.Context, req *api.AddGeoLocationRequest, opts ...grpc.CallOption) (*api.GeoLocationResponse, error) {
-	ctx, cancel := gc.contextWithOptions(ctx, gc.opts)
+	ctx, cancel := gc.contextWithOptions(ctx, "AddGeoLocation")
 	defer cancel()
 
-	resp, err := gc.client.AddGeoLocation(ctx, req)
+	resp, err := awaitLeader(ctx, gc.opts.LeaderWait, func() (*api.GeoLocationResponse, error) {
+		return gc.client.AddGeoLocation(ctx, req, gc.callOptions(opts)...)
+	})
+	gc.recordWrite(ctx)
 	if err != nil {
 		gc.Error("error adding geo location", zap.Error(err), zap.String("client", gc.opts.Caller))
 		return nil, err
@@ -175,10 +393,12 @@ func (gc *geoClient) AddGeo(ctx context.Context, req *api.AddGeoLocationRequest,
 }
 
 func (gc *geoClient) GetGeo(ctx context.Context, req *api.GetGeoLocationRequest, opts ...grpc.CallOption) (*api.GeoLocationResponse, error) {
-	ctx, cancel := gc.contextWithOptions(ctx, gc.opts)
+	ctx, cancel := gc.contextWithOptions(ctx, "GetGeoLocation")
 	defer cancel()
 
-	resp, err := gc.client.GetGeoLocation(ctx, req)
+	resp, err := read(ctx, gc, "GetGeoLocation", opts, func(ctx context.Context) (*api.GeoLocationResponse, error) {
+		return gc.client.GetGeoLocation(ctx, req, gc.callOptions(opts)...)
+	})
 	if err != nil {
 		gc.Error("error fetching geo location", zap.Error(err), zap.String("client", gc.opts.Caller))
 		return nil, err
@@ -187,10 +407,12 @@ func (gc *geoClient) GetGeo(ctx context.Context, req *api.GetGeoLocationRequest,
 }
 
 func (gc *geoClient) GetGeos(ctx context.Context, req *api.GetGeoLocationRequest, opts ...grpc.CallOption) (*api.GeoLocationsResponse, error) {
-	ctx, cancel := gc.contextWithOptions(ctx, gc.opts)
+	ctx, cancel := gc.contextWithOptions(ctx, "GetGeoLocations")
 	defer cancel()
 
-	resp, err := gc.client.GetGeoLocations(ctx, req)
+	resp, err := read(ctx, gc, "GetGeoLocations", opts, func(ctx context.Context) (*api.GeoLocationsResponse, error) {
+		return gc.client.GetGeoLocations(ctx, req, gc.callOptions(opts)...)
+	})
 	if err != nil {
 		gc.Error("error fetching geo locations", zap.Error(err), zap.String("client", gc.opts.Caller))
 		return nil, err
@@ -199,10 +421,13 @@ func (gc *geoClient) GetGeos(ctx context.Context, req *api.GetGeoLocationRequest
 }
 
 func (gc *geoClient) DeleteGeo(ctx context.Context, req *api.DeleteGeoLocationRequest, opts ...grpc.CallOption) (*api.DeleteResponse, error) {
-	ctx, cancel := gc.contextWithOptions(ctx, gc.opts)
+	ctx, cancel := gc.contextWithOptions(ctx, "DeleteGeoLocation")
 	defer cancel()
 
-	resp, err := gc.client.DeleteGeoLocation(ctx, req)
+	resp, err := awaitLeader(ctx, gc.opts.LeaderWait, func() (*api.DeleteResponse, error) {
+		return gc.client.DeleteGeoLocation(ctx, req, gc.callOptions(opts)...)
+	})
+	gc.recordWrite(ctx)
 	if err != nil {
 		gc.Error("error deleting geo location", zap.Error(err), zap.String("client", gc.opts.Caller))
 		return nil, err
@@ -211,10 +436,13 @@ func (gc *geoClient) DeleteGeo(ctx context.Context, req *api.DeleteGeoLocationRe
 }
 
 func (gc *geoClient) AddAddress(ctx context.Context, req *api.AddressRequest, opts ...grpc.CallOption) (*api.AddressResponse, error) {
-	ctx, cancel := gc.contextWithOptions(ctx, gc.opts)
+	ctx, cancel := gc.contextWithOptions(ctx, "AddAddress")
 	defer cancel()
 
-	resp, err := gc.client.AddAddress(ctx, req)
+	resp, err := awaitLeader(ctx, gc.opts.LeaderWait, func() (*api.AddressResponse, error) {
+		return gc.client.AddAddress(ctx, req, gc.callOptions(opts)...)
+	})
+	gc.recordWrite(ctx)
 	if err != nil {
 		gc.Error("error adding address", zap.Error(err), zap.String("client", gc.opts.Caller))
 		return nil, err
@@ -223,10 +451,13 @@ func (gc *geoClient) AddAddress(ctx context.Context, req *api.AddressRequest, op
 }
 
 func (gc *geoClient) UpdateAddress(ctx context.Context, req *api.AddressRequest, opts ...grpc.CallOption) (*api.AddressResponse, error) {
-	ctx, cancel := gc.contextWithOptions(ctx, gc.opts)
+	ctx, cancel := gc.contextWithOptions(ctx, "UpdateAddress")
 	defer cancel()
 
-	resp, err := gc.client.UpdateAddress(ctx, req)
+	resp, err := awaitLeader(ctx, gc.opts.LeaderWait, func() (*api.AddressResponse, error) {
+		return gc.client.UpdateAddress(ctx, req, gc.callOptions(opts)...)
+	})
+	gc.recordWrite(ctx)
 	if err != nil {
 		gc.Error("error updating address", zap.Error(err), zap.String("client", gc.opts.Caller))
 		return nil, err
@@ -235,10 +466,12 @@ func (gc *geoClient) UpdateAddress(ctx context.Context, req *api.AddressRequest,
 }
 
 func (gc *geoClient) GetAddress(ctx context.Context, req *api.GetAddressRequest, opts ...grpc.CallOption) (*api.AddressResponse, error) {
-	ctx, cancel := gc.contextWithOptions(ctx, gc.opts)
+	ctx, cancel := gc.contextWithOptions(ctx, "GetAddress")
 	defer cancel()
 
-	resp, err := gc.client.GetAddress(ctx, req)
+	resp, err := read(ctx, gc, "GetAddress", opts, func(ctx context.Context) (*api.AddressResponse, error) {
+		return gc.client.GetAddress(ctx, req, gc.callOptions(opts)...)
+	})
 	if err != nil {
 		gc.Error("error fetching address", zap.Error(err), zap.String("client", gc.opts.Caller))
 		return nil, err
@@ -247,10 +480,12 @@ func (gc *geoClient) GetAddress(ctx context.Context, req *api.GetAddressRequest,
 }
 
 func (gc *geoClient) GetAddresses(ctx context.Context, req *api.GetAddressesRequest, opts ...grpc.CallOption) (*api.AddressesResponse, error) {
-	ctx, cancel := gc.contextWithOptions(ctx, gc.opts)
+	ctx, cancel := gc.contextWithOptions(ctx, "GetAddresses")
 	defer cancel()
 
-	resp, err := gc.client.GetAddresses(ctx, req)
+	resp, err := read(ctx, gc, "GetAddresses", opts, func(ctx context.Context) (*api.AddressesResponse, error) {
+		return gc.client.GetAddresses(ctx, req, gc.callOptions(opts)...)
+	})
 	if err != nil {
 		gc.Error("error fetching addresses", zap.Error(err), zap.String("client", gc.opts.Caller))
 		return nil, err
@@ -259,10 +494,12 @@ func (gc *geoClient) GetAddresses(ctx context.Context, req *api.GetAddressesRequ
 }
 
 func (gc *geoClient) GetAddressesByIds(ctx context.Context, req *api.GetAddressesRequest, opts ...grpc.CallOption) (*api.AddressesResponse, error) {
-	ctx, cancel := gc.contextWithOptions(ctx, gc.opts)
+	ctx, cancel := gc.contextWithOptions(ctx, "GetAddressesByIds")
 	defer cancel()
 
-	resp, err := gc.client.GetAddressesByIds(ctx, req)
+	resp, err := read(ctx, gc, "GetAddressesByIds", opts, func(ctx context.Context) (*api.AddressesResponse, error) {
+		return gc.client.GetAddressesByIds(ctx, req, gc.callOptions(opts)...)
+	})
 	if err != nil {
 		gc.Error("error fetching addresses", zap.Error(err), zap.String("client", gc.opts.Caller))
 		return nil, err
@@ -271,10 +508,13 @@ func (gc *geoClient) GetAddressesByIds(ctx context.Context, req *api.GetAddresse
 }
 
 func (gc *geoClient) DeleteAddress(ctx context.Context, req *api.DeleteAddressRequest, opts ...grpc.CallOption) (*api.DeleteResponse, error) {
-	ctx, cancel := gc.contextWithOptions(ctx, gc.opts)
+	ctx, cancel := gc.contextWithOptions(ctx, "DeleteAddress")
 	defer cancel()
 
-	resp, err := gc.client.DeleteAddress(ctx, req)
+	resp, err := awaitLeader(ctx, gc.opts.LeaderWait, func() (*api.DeleteResponse, error) {
+		return gc.client.DeleteAddress(ctx, req, gc.callOptions(opts)...)
+	})
+	gc.recordWrite(ctx)
 	if err != nil {
 		gc.Error("error deleting address", zap.Error(err), zap.String("client", gc.opts.Caller))
 		return nil, err
@@ -283,10 +523,12 @@ func (gc *geoClient) DeleteAddress(ctx context.Context, req *api.DeleteAddressRe
 }
 
 func (gc *geoClient) GetServers(ctx context.Context, req *api.GetServersRequest, opts ...grpc.CallOption) (*api.GetServersResponse, error) {
-	ctx, cancel := gc.contextWithOptions(ctx, gc.opts)
+	ctx, cancel := gc.contextWithOptions(ctx, "GetServers")
 	defer cancel()
 
-	resp, err := gc.client.GetServers(ctx, req)
+	resp, err := read(ctx, gc, "GetServers", opts, func(ctx context.Context) (*api.GetServersResponse, error) {
+		return gc.client.GetServers(ctx, req, gc.callOptions(opts)...)
+	})
 	if err != nil {
 		gc.Error("error getting server list", zap.Error(err), zap.String("client", gc.opts.Caller))
 		return nil, err
@@ -294,7 +536,13 @@ func (gc *geoClient) GetServers(ctx context.Context, req *api.GetServersRequest,
 	return resp, nil
 }
 
+func (gc *geoClient) Watch(ctx context.Context) <-chan ClusterEvent {
+	return gc.events.subscribe(ctx)
+}
+
 func (gc *geoClient) Close() error {
+	defer gc.events.close()
+
 	if err := gc.conn.Close(); err != nil {
 		gc.Error("error closing geo client connection", zap.Error(err), zap.String("client", gc.opts.Caller))
 		return err
@@ -302,12 +550,63 @@ func (gc *geoClient) Close() error {
 	return nil
 }
 
-func (gc *geoClient) contextWithOptions(ctx context.Context, opts *ClientOption) (context.Context, context.CancelFunc) {
-	ctx, cancel := context.WithTimeout(ctx, gc.opts.DialTimeout)
+// contextWithOptions applies the method's call timeout, the caller
+// metadata and the session routing. A tighter deadline already on ctx
+// still wins.
+func (gc *geoClient) contextWithOptions(ctx context.Context, method string) (context.Context, context.CancelFunc) {
+	var cancel context.CancelFunc
+	if timeout := gc.opts.callTimeout(method); timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
 	if gc.opts.Caller != "" {
 		md := metadata.New(map[string]string{"service-client": gc.opts.Caller})
 		ctx = metadata.NewOutgoingContext(ctx, md)
 	}
+	ctx = gc.withSession(ctx, method)
 
 	return ctx, cancel
 }
+
+// callOptions merges the client default call options with the per-call ones.
+func (gc *geoClient) callOptions(opts []grpc.CallOption) []grpc.CallOption {
+	if len(gc.opts.CallOptions) == 0 {
+		return opts
+	}
+	callOpts := make([]grpc.CallOption, 0, len(gc.opts.CallOptions)+len(opts))
+	callOpts = append(callOpts, gc.opts.CallOptions...)
+	return append(callOpts, opts...)
+}
+
+func (o *ClientOption) callTimeout(method string) time.Duration {
+	if timeout, ok := o.MethodTimeouts[method]; ok {
+		return timeout
+	}
+	return o.CallTimeout
+}
+
+// balancerConfig returns the validated balancer config JSON, with the
+// client locality when set.
+func (o *ClientOption) balancerConfig() (string, error) {
+	if o.BalancerConfig == "" && o.Locality == (Locality{}) {
+		return "", nil
+	}
+	js := o.BalancerConfig
+	if js == "" {
+		js = "{}"
+	}
+	cfg, err := loadbalance.ParseConfig([]byte(js))
+	if err != nil {
+		return "", err
+	}
+	if o.Locality == (Locality{}) || cfg.Locality != (Locality{}) {
+		return o.BalancerConfig, nil
+	}
+	cfg.Locality = o.Locality
+	b, err := json.Marshal(cfg)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
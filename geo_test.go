@@ -2,14 +2,32 @@ package geo
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"math"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	comffC "github.com/comfforts/comff-constants"
 	geo_v1 "github.com/comfforts/comff-geo/api/v1"
 	"github.com/comfforts/logger"
+
+	"github.com/comfforts/comff-geo-client/internal/loadbalance"
 )
 
 const TEST_DIR = "data"
@@ -237,3 +255,820 @@ func testAddressCRUD(t *testing.T, gc Client) {
 	require.NoError(t, err)
 	require.Equal(t, true, delResp.Ok)
 }
+
+func TestKeepAlive(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping keepalive test in short mode")
+	}
+	lis, teardown := setupTestServer(t, grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+		MinTime:             time.Second,
+		PermitWithoutStream: true,
+	}))
+	defer teardown()
+
+	gc := newTestClient(t, lis.Addr().String(),
+		WithKeepAlive(10*time.Second, time.Second),
+		WithPermitWithoutStream(true),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err := gc.GetServers(ctx, &geo_v1.GetServersRequest{})
+	require.NoError(t, err)
+
+	// let post call frames settle, then the idle connection only sees pings
+	time.Sleep(time.Second)
+	reads := lis.reads()
+	time.Sleep(11 * time.Second)
+	require.Greater(t, lis.reads(), reads)
+}
+
+func TestCallOptions(t *testing.T) {
+	lis, teardown := setupTestServer(t)
+	defer teardown()
+
+	var defaultHeader metadata.MD
+	gc := newTestClient(t, lis.Addr().String(), WithCallOptions(grpc.Header(&defaultHeader), grpc.WaitForReady(true)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var header metadata.MD
+	_, err := gc.GetServers(ctx, &geo_v1.GetServersRequest{}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Equal(t, []string{lis.Addr().String()}, header.Get("geo-server"))
+	require.Equal(t, []string{lis.Addr().String()}, defaultHeader.Get("geo-server"))
+}
+
+func TestCallTimeout(t *testing.T) {
+	clientOpts := NewDefaultClientOption()
+	WithMethodTimeout("GetAddress", time.Minute)(clientOpts)
+	gc := &geoClient{opts: clientOpts}
+
+	for method, want := range map[string]time.Duration{
+		"GeoLocate":   defaultCallTimeout,
+		"GetGeoRoute": defaultRouteTimeout,
+		"GetAddress":  time.Minute,
+	} {
+		ctx, cancel := gc.contextWithOptions(context.Background(), method)
+		deadline, ok := ctx.Deadline()
+		cancel()
+		require.True(t, ok)
+		require.WithinDuration(t, time.Now().Add(want), deadline, time.Second)
+	}
+
+	// caller's tighter deadline wins
+	parent, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ctx, cancel := gc.contextWithOptions(parent, "GetGeoRoute")
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	require.True(t, ok)
+	parentDeadline, _ := parent.Deadline()
+	require.Equal(t, parentDeadline, deadline)
+
+	// options not built with the defaults keep the default deadlines
+	lis, teardown := setupTestServer(t)
+	defer teardown()
+	nc, err := NewClient(logger.NewTestAppLogger(TEST_DIR), &ClientOption{
+		Addr:        lis.Addr().String(),
+		DialTimeout: time.Second,
+		Creds:       insecure.NewCredentials(),
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, nc.Close())
+	}()
+	require.Equal(t, defaultCallTimeout, nc.opts.callTimeout("GeoLocate"))
+	require.Equal(t, defaultRouteTimeout, nc.opts.callTimeout("GetGeoRoute"))
+	_, err = nc.GetServers(context.Background(), &geo_v1.GetServersRequest{})
+	require.NoError(t, err)
+
+	// set deadlines aren't defaulted, empty method timeouts ask for none
+	for _, tc := range []struct {
+		opts  *ClientOption
+		route time.Duration
+	}{
+		{opts: &ClientOption{CallTimeout: time.Minute}, route: time.Minute},
+		{opts: &ClientOption{MethodTimeouts: map[string]time.Duration{}}, route: 0},
+	} {
+		tc.opts.Addr = lis.Addr().String()
+		tc.opts.Creds = insecure.NewCredentials()
+		nc, err := NewClient(logger.NewTestAppLogger(TEST_DIR), tc.opts)
+		require.NoError(t, err)
+		require.Equal(t, tc.route, nc.opts.callTimeout("GetGeoRoute"))
+		require.NoError(t, nc.Close())
+	}
+}
+
+func TestClientsIsolation(t *testing.T) {
+	var clients []Client
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		lis, teardown := setupTestServer(t)
+		defer teardown()
+
+		gc := newTestClient(t, lis.Addr().String())
+		clients = append(clients, gc)
+		addrs = append(addrs, lis.Addr().String())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i := 0; i < 5; i++ {
+		for j, gc := range clients {
+			var header metadata.MD
+			resp, err := gc.GetServers(ctx, &geo_v1.GetServersRequest{}, grpc.Header(&header))
+			require.NoError(t, err)
+			require.Equal(t, addrs[j], resp.Servers[0].Addr)
+			require.Equal(t, []string{addrs[j]}, header.Get("geo-server"))
+		}
+	}
+}
+
+func TestSeeds(t *testing.T) {
+	lis, teardown := setupTestServer(t)
+	defer teardown()
+
+	// first seed is down
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	downAddr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	gc := newTestClient(t, downAddr, WithSeeds(lis.Addr().String()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resp, err := gc.GetServers(ctx, &geo_v1.GetServersRequest{})
+	require.NoError(t, err)
+	require.Equal(t, lis.Addr().String(), resp.Servers[0].Addr)
+
+	// seeds are added to a geo-cqrs target
+	gc = newTestClient(t, fmt.Sprintf("%s://%s", loadbalance.GeoCQRSResolverName, downAddr), WithSeeds(lis.Addr().String()))
+	resp, err = gc.GetServers(ctx, &geo_v1.GetServersRequest{})
+	require.NoError(t, err)
+	require.Equal(t, lis.Addr().String(), resp.Servers[0].Addr)
+
+	// other targets can't take seeds
+	_, err = New(
+		WithAddr("dns:///"+lis.Addr().String()),
+		WithSeeds(downAddr),
+		WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.ErrorIs(t, err, ErrSeedsWithTarget)
+}
+
+func TestWatch(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+
+	lis, teardown := setupTestServer(t)
+	defer teardown()
+
+	gc, err := New(
+		WithAddr(lis.Addr().String()),
+		WithLogger(logger),
+		WithTransportCredentials(insecure.NewCredentials()),
+		WithRefresh(10*time.Millisecond, 0),
+	)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	next := func(events <-chan ClusterEvent) ClusterEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(time.Second):
+			t.Fatal("no cluster event")
+		}
+		return ClusterEvent{}
+	}
+	leader := ClusterEvent{Type: LeaderElected, Addr: lis.Addr().String()}
+	follower := ClusterEvent{Type: FollowerAdded, Addr: "127.0.0.1:1"}
+
+	// the leader is elected by the first resolution, before or after
+	// the watch
+	events := gc.Watch(ctx)
+	require.Equal(t, leader, next(events))
+	lis.srv.setFollowers("127.0.0.1:1")
+	require.Equal(t, follower, next(events))
+
+	// later watches get the current membership first
+	late := gc.Watch(ctx)
+	require.Equal(t, leader, next(late))
+	require.Equal(t, follower, next(late))
+
+	// closing the client ends the watch
+	require.NoError(t, gc.Close())
+	for range events {
+	}
+	for range late {
+	}
+}
+
+func TestWatchClose(t *testing.T) {
+	h := newEventHub(logger.NewTestAppLogger(TEST_DIR))
+	goroutines := runtime.NumGoroutine()
+	subs := make([]<-chan ClusterEvent, 100)
+	for i := range subs {
+		subs[i] = h.subscribe(context.Background())
+	}
+	require.GreaterOrEqual(t, runtime.NumGoroutine(), goroutines+len(subs))
+
+	// closing ends the subscriptions without a done ctx
+	h.close()
+	h.close()
+	for _, events := range subs {
+		for range events {
+		}
+	}
+	require.Eventually(t, func() bool {
+		return runtime.NumGoroutine() < goroutines+len(subs)/2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestBalancerConfig(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+
+	lis, teardown := setupTestServer(t)
+	defer teardown()
+
+	_, err := New(
+		WithAddr(lis.Addr().String()),
+		WithLogger(logger),
+		WithTransportCredentials(insecure.NewCredentials()),
+		WithBalancerConfig(`{"commonRoute": "nearest"}`),
+	)
+	require.Error(t, err)
+
+	gc := newTestClient(t, lis.Addr().String(), WithBalancerConfig(`{"commonRoute": "leader", "readFromLeader": true}`))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err = gc.GetServers(ctx, &geo_v1.GetServersRequest{})
+	require.NoError(t, err)
+}
+
+func TestPickTrace(t *testing.T) {
+	lis, teardown := setupTestServer(t)
+	defer teardown()
+
+	traces := make(chan PickTrace, 100)
+	gc := newTestClient(t, lis.Addr().String(),
+		WithPickTrace(func(trace PickTrace) {
+			traces <- trace
+		}),
+	)
+
+	_, err := gc.GetServers(context.Background(), &geo_v1.GetServersRequest{}, grpc.WaitForReady(true))
+	require.NoError(t, err)
+
+	// picks fail until the server is connected
+	trace := <-traces
+	for trace.Err != nil {
+		require.Equal(t, "not ready", trace.Reason)
+		trace = <-traces
+	}
+	require.Equal(t, PickTrace{
+		Method: "/geo.v1.Geo/GetServers",
+		Route:  loadbalance.RouteCommon,
+		Addr:   lis.Addr().String(),
+		Reason: "no followers, leader",
+	}, trace)
+}
+
+func TestHedging(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+
+	leader, teardown := setupTestServer(t)
+	defer teardown()
+	slow, teardown := setupTestServer(t)
+	defer teardown()
+	slow.srv.delay = time.Second
+	fast, teardown := setupTestServer(t)
+	defer teardown()
+	leader.srv.setFollowers(slow.Addr().String(), fast.Addr().String())
+
+	_, err := New(
+		WithAddr(leader.Addr().String()),
+		WithLogger(logger),
+		WithTransportCredentials(insecure.NewCredentials()),
+		WithHedging(50*time.Millisecond, "AddAddress"),
+	)
+	require.ErrorIs(t, err, ErrNotHedgeable)
+
+	gc := newTestClient(t, leader.Addr().String(), WithHedging(50*time.Millisecond))
+
+	// wait for the followers
+	ctx := context.Background()
+	for _, follower := range []*testListener{slow, fast} {
+		_, err := gc.GetServers(WithServer(ctx, follower.Addr().String()), &geo_v1.GetServersRequest{}, grpc.WaitForReady(true))
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < 4; i++ {
+		start := time.Now()
+		_, err := gc.GetAddress(ctx, &geo_v1.GetAddressRequest{})
+		require.NoError(t, err)
+		require.Less(t, time.Since(start), 500*time.Millisecond)
+	}
+	// reads sent to the slow follower were hedged to the fast one
+	slowReads := atomic.LoadInt64(&slow.srv.reads)
+	require.Greater(t, slowReads, int64(0))
+	require.Equal(t, int64(4), atomic.LoadInt64(&fast.srv.reads))
+}
+
+func TestHealthCheck(t *testing.T) {
+	leader, teardown := setupTestServer(t)
+	defer teardown()
+	unhealthy, teardown := setupTestServer(t)
+	defer teardown()
+	healthy, teardown := setupTestServer(t)
+	defer teardown()
+	leader.srv.setFollowers(unhealthy.Addr().String(), healthy.Addr().String())
+
+	const service = "geo.v1.Geo"
+	leader.health.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	healthy.health.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	unhealthy.health.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+
+	gc := newTestClient(t, leader.Addr().String(), WithHealthCheck(service))
+
+	ctx := context.Background()
+	_, err := gc.GetServers(WithServer(ctx, healthy.Addr().String()), &geo_v1.GetServersRequest{}, grpc.WaitForReady(true))
+	require.NoError(t, err)
+	for i := 0; i < 4; i++ {
+		_, err := gc.GetAddress(ctx, &geo_v1.GetAddressRequest{})
+		require.NoError(t, err)
+	}
+	require.Equal(t, int64(0), atomic.LoadInt64(&unhealthy.srv.reads))
+	require.Equal(t, int64(4), atomic.LoadInt64(&healthy.srv.reads))
+
+	// recovered follower gets reads again
+	unhealthy.health.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	require.Eventually(t, func() bool {
+		_, err := gc.GetAddress(ctx, &geo_v1.GetAddressRequest{})
+		require.NoError(t, err)
+		return atomic.LoadInt64(&unhealthy.srv.reads) > 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRetry(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+
+	leader, teardown := setupTestServer(t)
+	defer teardown()
+	failing, teardown := setupTestServer(t)
+	defer teardown()
+	failing.srv.failReads = true
+	healthy, teardown := setupTestServer(t)
+	defer teardown()
+	leader.srv.setFollowers(failing.Addr().String(), healthy.Addr().String())
+
+	_, err := New(
+		WithAddr(leader.Addr().String()),
+		WithLogger(logger),
+		WithTransportCredentials(insecure.NewCredentials()),
+		WithRetry(RetryPolicy{Methods: []string{"AddAddress"}}),
+	)
+	require.ErrorIs(t, err, ErrNotRetryable)
+
+	gc := newTestClient(t, leader.Addr().String(), WithRetry(RetryPolicy{MaxAttempts: 2}))
+
+	// wait for the followers
+	ctx := context.Background()
+	for _, follower := range []*testListener{failing, healthy} {
+		_, err := gc.GetServers(WithServer(ctx, follower.Addr().String()), &geo_v1.GetServersRequest{}, grpc.WaitForReady(true))
+		require.NoError(t, err)
+	}
+
+	// reads failing on a follower are retried on the other
+	for i := 0; i < 4; i++ {
+		_, err := gc.GetAddress(ctx, &geo_v1.GetAddressRequest{})
+		require.NoError(t, err)
+	}
+	failed := atomic.LoadInt64(&failing.srv.reads)
+	require.Greater(t, failed, int64(0))
+	require.Equal(t, int64(4), atomic.LoadInt64(&healthy.srv.reads))
+
+	// pinned reads retry on the same server
+	_, err = gc.GetAddress(WithServer(ctx, failing.Addr().String()), &geo_v1.GetAddressRequest{})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, failed+2, atomic.LoadInt64(&failing.srv.reads))
+}
+
+func TestRetryPolicy(t *testing.T) {
+	policy, err := RetryPolicy{
+		MaxAttempts: 10,
+		Backoff: backoff.Config{
+			BaseDelay:  100 * time.Millisecond,
+			Multiplier: 2,
+			MaxDelay:   time.Second,
+		},
+	}.withDefaults()
+	require.NoError(t, err)
+
+	var calls int
+	call := func(code codes.Code) func(context.Context) (int, error) {
+		calls = 0
+		return func(context.Context) (int, error) {
+			calls++
+			return calls, status.Error(code, "failed")
+		}
+	}
+
+	// non retryable code
+	_, err = retry(context.Background(), policy, call(codes.NotFound))
+	require.Equal(t, codes.NotFound, status.Code(err))
+	require.Equal(t, 1, calls)
+
+	// retries stop before the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = retry(ctx, policy, call(codes.Unavailable))
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, 2, calls)
+	require.Less(t, time.Since(start), 250*time.Millisecond)
+
+	// up to the max attempts
+	policy.Backoff.BaseDelay = time.Millisecond
+	_, err = retry(context.Background(), policy, call(codes.Unavailable))
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, 10, calls)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	policy, err := RetryPolicy{}.withDefaults()
+	require.NoError(t, err)
+	require.Equal(t, defaultRetryBackoff, policy.Backoff)
+
+	// unset values of a partial backoff are defaulted each
+	policy, err = RetryPolicy{
+		Backoff: backoff.Config{MaxDelay: 50 * time.Millisecond},
+	}.withDefaults()
+	require.NoError(t, err)
+	require.Equal(t, backoff.Config{
+		BaseDelay:  100 * time.Millisecond,
+		Multiplier: 2,
+		MaxDelay:   50 * time.Millisecond,
+	}, policy.Backoff)
+	policy, err = RetryPolicy{
+		Backoff: backoff.Config{BaseDelay: 2 * time.Second, Jitter: 0.1},
+	}.withDefaults()
+	require.NoError(t, err)
+	require.Equal(t, backoff.Config{
+		BaseDelay:  2 * time.Second,
+		Multiplier: 2,
+		Jitter:     0.1,
+		MaxDelay:   2 * time.Second,
+	}, policy.Backoff)
+	require.Greater(t, loadbalance.BackoffDelay(policy.Backoff, 1), time.Second)
+
+	for _, b := range []backoff.Config{
+		{Multiplier: 0.5},
+		{BaseDelay: -time.Second},
+		{Jitter: 2},
+	} {
+		_, err = RetryPolicy{Backoff: b}.withDefaults()
+		require.ErrorIs(t, err, ErrInvalidBackoff)
+	}
+}
+
+func TestHedge(t *testing.T) {
+	errFailed := errors.New("failed")
+	var calls int64
+	call := func(delays ...time.Duration) func(context.Context) (int64, error) {
+		atomic.StoreInt64(&calls, 0)
+		return func(ctx context.Context) (int64, error) {
+			n := atomic.AddInt64(&calls, 1)
+			select {
+			case <-ctx.Done():
+				return n, ctx.Err()
+			case <-time.After(delays[n-1]):
+			}
+			if delays[n-1] < 0 {
+				return n, errFailed
+			}
+			return n, nil
+		}
+	}
+	ctx := context.Background()
+
+	// first answer wins
+	n, err := hedge(ctx, 10*time.Millisecond, call(time.Second, 0))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), n)
+
+	// fast answer isn't hedged
+	n, err = hedge(ctx, 100*time.Millisecond, call(0))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+
+	// failure isn't hedged
+	_, err = hedge(ctx, 100*time.Millisecond, call(-1))
+	require.ErrorIs(t, err, errFailed)
+	require.Equal(t, int64(1), atomic.LoadInt64(&calls))
+
+	// hedged call failure waits for the other call
+	n, err = hedge(ctx, 10*time.Millisecond, call(50*time.Millisecond, -1))
+	require.NoError(t, err)
+	require.Equal(t, int64(1), n)
+}
+
+func TestLocalityConfig(t *testing.T) {
+	opts := NewDefaultClientOption()
+	js, err := opts.balancerConfig()
+	require.NoError(t, err)
+	require.Equal(t, "", js)
+
+	opts.Locality = Locality{Zone: "us-east-1a", Region: "us-east-1"}
+	opts.BalancerConfig = `{"followerSelection": "random"}`
+	js, err = opts.balancerConfig()
+	require.NoError(t, err)
+	cfg, err := loadbalance.ParseConfig([]byte(js))
+	require.NoError(t, err)
+	require.Equal(t, loadbalance.SelectRandom, cfg.FollowerSelection)
+	require.Equal(t, opts.Locality, cfg.Locality)
+
+	// balancer config locality wins
+	opts.BalancerConfig = `{"zone": "us-east-1b"}`
+	js, err = opts.balancerConfig()
+	require.NoError(t, err)
+	require.Equal(t, opts.BalancerConfig, js)
+}
+
+func TestSession(t *testing.T) {
+	routed := func(gc *geoClient, ctx context.Context, method string) bool {
+		ctx, cancel := gc.contextWithOptions(ctx, method)
+		defer cancel()
+		route, ok := loadbalance.RouteFromContext(ctx)
+		return ok && route == loadbalance.RouteLeader
+	}
+
+	// context session
+	gc := &geoClient{opts: NewDefaultClientOption()}
+	ctx := ContextWithSession(context.Background(), NewSession(50*time.Millisecond))
+	require.False(t, routed(gc, ctx, "GetAddress"))
+
+	gc.recordWrite(ctx)
+	require.True(t, routed(gc, ctx, "GetAddress"))
+	require.True(t, routed(gc, ctx, "GeoLocate"))
+	require.False(t, routed(gc, context.Background(), "GetAddress"))
+
+	time.Sleep(60 * time.Millisecond)
+	require.False(t, routed(gc, ctx, "GetAddress"))
+
+	// client session
+	gc = &geoClient{opts: NewDefaultClientOption(), sess: NewSession(time.Minute)}
+	gc.recordWrite(context.Background())
+	require.True(t, routed(gc, context.Background(), "GetAddress"))
+
+	// call routing override wins over the session
+	require.False(t, routed(gc, WithFollower(context.Background()), "GetAddress"))
+}
+
+func TestRouteOverride(t *testing.T) {
+	leader, teardown := setupTestServer(t)
+	defer teardown()
+	follower, teardown := setupTestServer(t)
+	defer teardown()
+	leader.srv.setFollowers(follower.Addr().String())
+
+	gc := newTestClient(t, leader.Addr().String(), WithRefresh(10*time.Millisecond, 0))
+
+	server := func(ctx context.Context, opts ...grpc.CallOption) string {
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		var header metadata.MD
+		opts = append(opts, grpc.WaitForReady(true), grpc.Header(&header))
+		_, err := gc.GetServers(ctx, &geo_v1.GetServersRequest{}, opts...)
+		require.NoError(t, err)
+		return header.Get("geo-server")[0]
+	}
+	ctx := context.Background()
+	require.Equal(t, follower.Addr().String(), server(ctx, ToServer(follower.Addr().String())))
+	require.Equal(t, leader.Addr().String(), server(ctx, ToServer(leader.Addr().String())))
+	require.Equal(t, follower.Addr().String(), server(WithServer(ctx, follower.Addr().String())))
+	require.Equal(t, leader.Addr().String(), server(ctx, ToLeader()))
+	require.Equal(t, leader.Addr().String(), server(WithLeader(ctx)))
+	require.Equal(t, follower.Addr().String(), server(WithFollower(ctx)))
+	// call option wins over the context
+	require.Equal(t, follower.Addr().String(), server(WithLeader(ctx), ToFollower()))
+
+	// unknown server fails fast
+	_, err := gc.GetServers(WithServer(ctx, "127.0.0.1:1"), &geo_v1.GetServersRequest{})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+
+	// wait for ready calls wait for the server until their deadline
+	waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = gc.GetServers(WithServer(waitCtx, "127.0.0.1:1"), &geo_v1.GetServersRequest{}, grpc.WaitForReady(true))
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+
+	joined, teardown := setupTestServer(t)
+	defer teardown()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		leader.srv.setFollowers(follower.Addr().String(), joined.Addr().String())
+	}()
+	require.Equal(t, joined.Addr().String(), server(WithServer(ctx, joined.Addr().String())))
+}
+
+func TestNoLeader(t *testing.T) {
+	lis, teardown := setupTestServer(t)
+	defer teardown()
+	lis.srv.setFollower(true)
+
+	gc := newTestClient(t, lis.Addr().String(), WithRefresh(10*time.Millisecond, 0))
+
+	// reads are served, writes fail fast
+	ctx := context.Background()
+	_, err := gc.GetServers(ctx, &geo_v1.GetServersRequest{}, grpc.WaitForReady(true))
+	require.NoError(t, err)
+	start := time.Now()
+	_, err = gc.AddAddress(ctx, &geo_v1.AddressRequest{})
+	require.True(t, IsNoLeader(err), err)
+	require.Less(t, time.Since(start), time.Second)
+	// the servers' own Unavailable errors are not
+	require.False(t, IsNoLeader(status.Error(codes.Unavailable, err.Error())))
+
+	// writes wait for the leader election
+	gc.opts.LeaderWait = 2 * time.Second
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		lis.srv.setFollower(false)
+	}()
+	_, err = gc.AddAddress(ctx, &geo_v1.AddressRequest{})
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestLeaderFailure(t *testing.T) {
+	leader, stopLeader := setupTestServer(t)
+	defer stopLeader()
+	follower, teardown := setupTestServer(t)
+	defer teardown()
+	leader.srv.setFollowers(follower.Addr().String())
+
+	gc := newTestClient(t, leader.Addr().String())
+
+	ctx := context.Background()
+	_, err := gc.AddAddress(ctx, &geo_v1.AddressRequest{}, grpc.WaitForReady(true))
+	require.Equal(t, codes.Unimplemented, status.Code(err))
+
+	// the resolved leader crashes, writes fail fast once it's in
+	// transient failure instead of waiting for it
+	stopLeader()
+	require.Eventually(t, func() bool {
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		_, err := gc.AddAddress(ctx, &geo_v1.AddressRequest{})
+		return IsNoLeader(err)
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err = gc.GetAddress(ctx, &geo_v1.GetAddressRequest{})
+	require.NoError(t, err)
+}
+
+// testGeoServer serves the in-process geo cluster with itself as leader.
+type testGeoServer struct {
+	geo_v1.UnimplementedGeoServer
+	addr      string
+	mu        sync.Mutex
+	followers []string
+	follower  bool
+	delay     time.Duration
+	failReads bool
+	reads     int64
+}
+
+func (s *testGeoServer) setFollowers(addrs ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.followers = addrs
+}
+
+// GetAddress counts the reads, answering after the server delay, or
+// failing with Unavailable.
+func (s *testGeoServer) GetAddress(ctx context.Context, req *geo_v1.GetAddressRequest) (*geo_v1.AddressResponse, error) {
+	atomic.AddInt64(&s.reads, 1)
+	if s.failReads {
+		return nil, status.Error(codes.Unavailable, "geocoder unavailable")
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(s.delay):
+	}
+	return &geo_v1.AddressResponse{}, nil
+}
+
+// setFollower makes the server list itself as a follower, leaving the
+// cluster without leader.
+func (s *testGeoServer) setFollower(follower bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.follower = follower
+}
+
+func (s *testGeoServer) GetServers(ctx context.Context, req *geo_v1.GetServersRequest) (*geo_v1.GetServersResponse, error) {
+	if err := grpc.SetHeader(ctx, metadata.Pairs("geo-server", s.addr)); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	servers := []*geo_v1.Server{
+		{
+			Id:       "geo-test-leader",
+			Addr:     s.addr,
+			IsLeader: !s.follower,
+		},
+	}
+	for _, addr := range s.followers {
+		servers = append(servers, &geo_v1.Server{
+			Id:   addr,
+			Addr: addr,
+		})
+	}
+	return &geo_v1.GetServersResponse{Servers: servers}, nil
+}
+
+// testListener counts reads across accepted connections.
+type testListener struct {
+	net.Listener
+	count  *int64
+	srv    *testGeoServer
+	health *health.Server
+}
+
+func (l *testListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &testConn{Conn: conn, count: l.count}, nil
+}
+
+func (l *testListener) reads() int64 {
+	return atomic.LoadInt64(l.count)
+}
+
+type testConn struct {
+	net.Conn
+	count *int64
+}
+
+func (c *testConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		atomic.AddInt64(c.count, 1)
+	}
+	return n, err
+}
+
+func setupTestServer(t *testing.T, opts ...grpc.ServerOption) (
+	lis *testListener,
+	teardown func(),
+) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	lis = &testListener{
+		Listener: l,
+		count:    new(int64),
+		srv:      &testGeoServer{addr: l.Addr().String()},
+		health:   health.NewServer(),
+	}
+
+	srv := grpc.NewServer(opts...)
+	geo_v1.RegisterGeoServer(srv, lis.srv)
+	healthpb.RegisterHealthServer(srv, lis.health)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+
+	return lis, func() {
+		srv.Stop()
+	}
+}
+
+// newTestClient connects an insecure client to the addr servers,
+// closing it when the test ends.
+func newTestClient(t *testing.T, addr string, opts ...Option) *geoClient {
+	t.Helper()
+
+	gc, err := New(append([]Option{
+		WithAddr(addr),
+		WithLogger(logger.NewTestAppLogger(TEST_DIR)),
+		WithTransportCredentials(insecure.NewCredentials()),
+	}, opts...)...)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, gc.Close())
+	})
+	return gc
+}
@@ -0,0 +1,109 @@
+package geo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/comfforts/comff-geo-client/internal/loadbalance"
+)
+
+// ErrNotHedgeable is returned for hedging config on methods other than reads.
+var ErrNotHedgeable = errors.New("only reads can be hedged")
+
+// defaultHedgedMethods are hedged by WithHedging when no method is given.
+var defaultHedgedMethods = []string{"GeoLocate", "GetAddress", "GetAddressesByIds"}
+
+// hedge makes the call, and the same call again when the first hasn't
+// answered within delay, returning the first success, or the first
+// error when both fail. The calls share attempts, so the second one goes
+// to another follower. The losing call is canceled.
+func hedge[T any](ctx context.Context, delay time.Duration, call func(context.Context) (T, error)) (T, error) {
+	if delay <= 0 {
+		return call(ctx)
+	}
+	if loadbalance.AttemptsFromContext(ctx) == nil {
+		ctx = loadbalance.WithAttempts(ctx, &loadbalance.Attempts{})
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		resp T
+		err  error
+	}
+	results := make(chan result, 2)
+	attempt := func() {
+		resp, err := call(ctx)
+		results <- result{resp: resp, err: err}
+	}
+	go attempt()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	pending := 1
+	var first *result
+	for {
+		select {
+		case <-timer.C:
+			if first == nil {
+				pending++
+				go attempt()
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.resp, nil
+			}
+			if first == nil {
+				first = &r
+			}
+			if pending == 0 {
+				return first.resp, first.err
+			}
+		}
+	}
+}
+
+// hedgeDelay returns the method hedging delay, zero when the call
+// isn't hedged: hedging isn't configured for the method, the call is
+// routed to the leader or a server, or it captures the call header,
+// trailer or peer, which hedged calls would both write.
+func (gc *geoClient) hedgeDelay(ctx context.Context, method string, opts []grpc.CallOption) time.Duration {
+	delay := gc.opts.HedgeDelays[method]
+	if delay <= 0 {
+		return 0
+	}
+	if route, ok := loadbalance.RouteFromContext(ctx); ok && route != loadbalance.RouteFollower {
+		return 0
+	}
+	if _, ok := loadbalance.ServerFromContext(ctx); ok {
+		return 0
+	}
+	for _, opt := range gc.callOptions(opts) {
+		switch o := opt.(type) {
+		case routeCallOption:
+			if o.addr != "" || o.route != loadbalance.RouteFollower {
+				return 0
+			}
+		case grpc.HeaderCallOption, grpc.TrailerCallOption, grpc.PeerCallOption:
+			return 0
+		}
+	}
+	return delay
+}
+
+// validateHedging checks only reads are hedged.
+func (o *ClientOption) validateHedging() error {
+	for method := range o.HedgeDelays {
+		route, ok := loadbalance.MethodRoute(loadbalance.FullMethod(method))
+		if !ok || route != loadbalance.RouteFollower {
+			return fmt.Errorf("%w: %s", ErrNotHedgeable, method)
+		}
+	}
+	return nil
+}
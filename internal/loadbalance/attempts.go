@@ -0,0 +1,61 @@
+package loadbalance
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc/balancer"
+)
+
+// Attempts tracks the SubConns picked for the attempts of a request,
+// e.g. hedged or retried reads, so the next attempts prefer other
+// followers.
+type Attempts struct {
+	mu     sync.Mutex
+	picked map[balancer.SubConn]bool
+}
+
+type attemptsKey struct{}
+
+// WithAttempts tracks the picks of the requests made with ctx in a.
+func WithAttempts(ctx context.Context, a *Attempts) context.Context {
+	return context.WithValue(ctx, attemptsKey{}, a)
+}
+
+// AttemptsFromContext returns the attempts set by WithAttempts.
+func AttemptsFromContext(ctx context.Context) *Attempts {
+	if ctx == nil {
+		return nil
+	}
+	a, _ := ctx.Value(attemptsKey{}).(*Attempts)
+	return a
+}
+
+func (a *Attempts) add(sc balancer.SubConn) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.picked == nil {
+		a.picked = make(map[balancer.SubConn]bool)
+	}
+	a.picked[sc] = true
+}
+
+// untried returns the SubConns not picked yet, or all of them when
+// they have all been tried.
+func (a *Attempts) untried(scs []balancer.SubConn) []balancer.SubConn {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.picked) == 0 {
+		return scs
+	}
+	untried := make([]balancer.SubConn, 0, len(scs))
+	for _, sc := range scs {
+		if !a.picked[sc] {
+			untried = append(untried, sc)
+		}
+	}
+	if len(untried) == 0 {
+		return scs
+	}
+	return untried
+}
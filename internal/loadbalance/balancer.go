@@ -0,0 +1,313 @@
+package loadbalance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	// client health checking, enabled by the service config healthCheckConfig
+	_ "google.golang.org/grpc/health"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrInvalidRoute     = errors.New("invalid route")
+	ErrInvalidSelection = errors.New("invalid follower selection")
+	ErrInvalidProbe     = errors.New("invalid latency probe")
+	ErrInvalidPolicy    = errors.New("invalid common policy")
+	// ErrServerNotReady fails the picks for a pinned server that isn't
+	// connected. Unlike ErrNoLeader it isn't a status error, gRPC fails
+	// the calls with Unavailable, or blocks wait for ready calls until
+	// the server connects.
+	ErrServerNotReady = errors.New("server not ready")
+	// ErrNoLeader fails the writes when no leader is known, or the leader
+	// is in transient failure. It's an Unavailable status error, gRPC ends
+	// the call with it as is, wait for ready calls included, so callers
+	// tell it apart from a server's Unavailable with errors.Is.
+	ErrNoLeader error = noLeaderError{}
+)
+
+// noLeaderError is the ErrNoLeader pick error.
+type noLeaderError struct{}
+
+func (noLeaderError) Error() string {
+	return "no leader"
+}
+
+func (noLeaderError) GRPCStatus() *status.Status {
+	return status.New(codes.Unavailable, "no leader")
+}
+
+// Selection is the follower selection strategy for reads.
+type Selection string
+
+const (
+	SelectRoundRobin Selection = "round_robin"
+	SelectRandom     Selection = "random"
+	// SelectLeastLoaded picks the follower with the fewest requests in flight.
+	SelectLeastLoaded Selection = "least_loaded"
+	// SelectPowerOfTwo picks the less loaded of two random followers.
+	SelectPowerOfTwo Selection = "power_of_two"
+	// SelectLatency favors the followers with the lowest moving average
+	// latency, probing the others with LatencyProbe of the reads.
+	SelectLatency Selection = "latency"
+)
+
+// CommonPolicy picks the server for the common requests, routed to
+// neither the leader nor the followers.
+type CommonPolicy string
+
+const (
+	// CommonAny picks the current round robin server, without advancing.
+	CommonAny CommonPolicy = "any"
+	// CommonLeaderPreferred picks the leader, or a follower without leader.
+	CommonLeaderPreferred CommonPolicy = "leader_preferred"
+	// CommonRoundRobin picks round robin over the leader and followers.
+	CommonRoundRobin CommonPolicy = "round_robin"
+)
+
+// defaultLatencyProbe is the share of reads probing the followers
+// round robin with latency selection.
+const defaultLatencyProbe = 0.05
+
+var _ balancer.Builder = (*balancerBuilder)(nil)
+var _ balancer.ConfigParser = (*balancerBuilder)(nil)
+
+// Config is the geo-cqrs balancer config, from the service config
+// loadBalancingConfig, e.g.
+//
+//	{"loadBalancingConfig":[{"geo-cqrs":{"followerSelection":"random","routes":{"/geo.v1.Geo/GetAddress":"leader"}}}]}
+type Config struct {
+	serviceconfig.LoadBalancingConfig `json:"-"`
+
+	// FollowerSelection picks the follower for reads, round robin by default.
+	FollowerSelection Selection `json:"followerSelection,omitempty"`
+	// LatencyProbe is the share of reads, between 0 and 1, sent round
+	// robin with latency selection, 0.05 when not set.
+	LatencyProbe *float64 `json:"latencyProbe,omitempty"`
+	// ReadFromLeader includes the leader in the servers picked for reads.
+	ReadFromLeader bool `json:"readFromLeader,omitempty"`
+	// CommonRoute routes the common requests, e.g. GetServers.
+	CommonRoute Route `json:"commonRoute,omitempty"`
+	// CommonPolicy picks the server for the requests with the common
+	// route, any server by default.
+	CommonPolicy CommonPolicy `json:"commonPolicy,omitempty"`
+	// DefaultRoute routes methods missing from the routing table.
+	DefaultRoute Route `json:"defaultRoute,omitempty"`
+	// Routes override the routing table, keyed on the full method name.
+	Routes map[string]Route `json:"routes,omitempty"`
+	// OutlierEjection, when set, ejects the failing followers.
+	OutlierEjection *OutlierEjection `json:"outlierEjection,omitempty"`
+	// Locality is the client zone and region. Reads prefer the followers
+	// in the same zone, then region, falling back to all followers.
+	Locality
+}
+
+func (c *Config) latencyProbe() float64 {
+	if c.LatencyProbe == nil {
+		return defaultLatencyProbe
+	}
+	return *c.LatencyProbe
+}
+
+// ParseConfig parses and validates the balancer config JSON.
+func ParseConfig(js []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := json.Unmarshal(js, cfg); err != nil {
+		return nil, fmt.Errorf("%s: invalid balancer config: %w", GeoCQRSResolverName, err)
+	}
+	switch cfg.FollowerSelection {
+	case "", SelectRoundRobin, SelectRandom, SelectLeastLoaded, SelectPowerOfTwo, SelectLatency:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSelection, cfg.FollowerSelection)
+	}
+	switch cfg.CommonPolicy {
+	case "", CommonAny, CommonLeaderPreferred, CommonRoundRobin:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidPolicy, cfg.CommonPolicy)
+	}
+	if cfg.OutlierEjection != nil {
+		if err := cfg.OutlierEjection.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.LatencyProbe != nil && (*cfg.LatencyProbe < 0 || *cfg.LatencyProbe > 1) {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProbe, *cfg.LatencyProbe)
+	}
+	for _, route := range []Route{cfg.CommonRoute, cfg.DefaultRoute} {
+		if route == "" {
+			continue
+		}
+		if err := route.validate(); err != nil {
+			return nil, err
+		}
+	}
+	for method, route := range cfg.Routes {
+		if err := route.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", method, err)
+		}
+	}
+	return cfg, nil
+}
+
+func init() {
+	balancer.Register(&balancerBuilder{})
+}
+
+// balancerBuilder builds the base balancer with a picker builder
+// per client connection, configured from the balancer config. SubConns
+// are health checked when the service config has a healthCheckConfig.
+type balancerBuilder struct{}
+
+func (bb *balancerBuilder) Name() string {
+	return GeoCQRSResolverName
+}
+
+func (bb *balancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	b := &geoBalancer{
+		pb:      &pickerBuilder{load: &Load{}},
+		leaders: make(map[balancer.SubConn]connectivity.State),
+	}
+	b.cc = &balancerConn{ClientConn: cc, b: b}
+	b.Balancer = base.NewBalancerBuilder(GeoCQRSResolverName, b.pb, base.Config{HealthCheck: true}).Build(b.cc, opts)
+	return b
+}
+
+func (bb *balancerBuilder) ParseConfig(js json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	return ParseConfig(js)
+}
+
+// geoBalancer passes the balancer config to the picker builder, and
+// tracks the state of the leader SubConns. gRPC serializes the balancer
+// calls, so its state needs no lock.
+type geoBalancer struct {
+	balancer.Balancer
+	pb      *pickerBuilder
+	cc      *balancerConn
+	leaders map[balancer.SubConn]connectivity.State
+}
+
+func (b *geoBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	if cfg, ok := s.BalancerConfig.(*Config); ok {
+		b.pb.config = cfg
+	}
+	if d := diagnosticsFrom(s.ResolverState); d != nil && d != b.pb.diagnostics {
+		b.pb.diagnostics = d
+		if d.Logger != nil {
+			b.pb.logger = d.Logger.Named(fmt.Sprintf("%s-picker", GeoCQRSResolverName))
+		}
+	}
+	b.pb.leaderResolved = false
+	for _, addr := range s.ResolverState.Addresses {
+		if isLeader(addr) {
+			b.pb.leaderResolved = true
+		}
+	}
+	return b.Balancer.UpdateClientConnState(s)
+}
+
+// UpdateSubConnState fails the writes fast while the leader is in
+// transient failure, writes wait for it only while it's connecting.
+// Like the base balancer, the failure sticks until the leader is ready.
+func (b *geoBalancer) UpdateSubConnState(sc balancer.SubConn, state balancer.SubConnState) {
+	failed := b.pb.leaderFailed
+	if old, ok := b.leaders[sc]; ok {
+		s := state.ConnectivityState
+		switch {
+		case s == connectivity.Shutdown:
+			delete(b.leaders, sc)
+		case old == connectivity.TransientFailure && (s == connectivity.Idle || s == connectivity.Connecting):
+			// reconnecting after the failure
+		default:
+			b.leaders[sc] = s
+		}
+		b.pb.leaderFailed = b.leaderFailed()
+	}
+	if state.ConnectivityState == connectivity.Shutdown {
+		b.pb.load.remove(sc)
+	}
+	b.Balancer.UpdateSubConnState(sc, state)
+	if b.pb.leaderFailed != failed {
+		b.cc.regeneratePicker()
+	}
+}
+
+// leaderFailed reports whether all the leader SubConns are in
+// transient failure.
+func (b *geoBalancer) leaderFailed() bool {
+	for _, s := range b.leaders {
+		if s != connectivity.TransientFailure {
+			return false
+		}
+	}
+	return len(b.leaders) > 0
+}
+
+// balancerConn records the leader SubConns the base balancer creates,
+// and the last state it sent, to rebuild its picker on leader changes.
+type balancerConn struct {
+	balancer.ClientConn
+	b     *geoBalancer
+	state balancer.State
+}
+
+func (cc *balancerConn) NewSubConn(addrs []resolver.Address, opts balancer.NewSubConnOptions) (balancer.SubConn, error) {
+	sc, err := cc.ClientConn.NewSubConn(addrs, opts)
+	if err == nil && len(addrs) == 1 && isLeader(addrs[0]) {
+		cc.b.leaders[sc] = connectivity.Idle
+	}
+	return sc, err
+}
+
+func (cc *balancerConn) UpdateState(s balancer.State) {
+	cc.state = s
+	cc.ClientConn.UpdateState(s)
+}
+
+// regeneratePicker rebuilds the last picker of the base balancer with
+// the current leader state. Error pickers are kept.
+func (cc *balancerConn) regeneratePicker() {
+	if _, ok := cc.state.Picker.(*Picker); !ok {
+		return
+	}
+	cc.UpdateState(balancer.State{
+		ConnectivityState: cc.state.ConnectivityState,
+		Picker:            cc.b.pb.Build(cc.b.pb.info),
+	})
+}
+
+// pickerBuilder builds a new Picker on every update, so pickers
+// are never shared between client connections. The request load
+// outlives the pickers.
+type pickerBuilder struct {
+	config         *Config
+	load           *Load
+	leaderResolved bool
+	leaderFailed   bool
+	diagnostics    *Diagnostics
+	logger         *zap.Logger
+	// info is the last build info, to rebuild the picker.
+	info base.PickerBuildInfo
+}
+
+func (pb *pickerBuilder) Build(buildInfo base.PickerBuildInfo) balancer.Picker {
+	pb.info = buildInfo
+	p := &Picker{
+		Config:         pb.config,
+		Load:           pb.load,
+		LeaderResolved: pb.leaderResolved,
+		LeaderFailed:   pb.leaderFailed,
+		Logger:         pb.logger,
+	}
+	if pb.diagnostics != nil {
+		p.OnPick = pb.diagnostics.OnPick
+	}
+	return p.Build(buildInfo)
+}
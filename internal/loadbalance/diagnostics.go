@@ -0,0 +1,46 @@
+package loadbalance
+
+import (
+	"go.uber.org/zap"
+	"google.golang.org/grpc/resolver"
+)
+
+// PickTrace describes a pick decision.
+type PickTrace struct {
+	// Method is the full method name.
+	Method string
+	// Route is the request route, empty for a pinned server.
+	Route Route
+	// Addr is the picked server, empty when the pick failed.
+	Addr string
+	// Reason explains the decision, e.g. "follower round_robin".
+	Reason string
+	// Err is the pick error, if any.
+	Err error
+}
+
+// Diagnostics configures the balancer logging and pick tracing. The
+// resolver passes them to the balancer in the resolver state attributes.
+type Diagnostics struct {
+	// Logger logs the picks at debug level.
+	Logger *zap.Logger
+	// OnPick, when set, is called with every pick decision. It must
+	// not block.
+	OnPick func(PickTrace)
+}
+
+type diagnosticsKey struct{}
+
+// withDiagnostics adds the diagnostics to the resolver state.
+func withDiagnostics(s resolver.State, d *Diagnostics) resolver.State {
+	if d != nil {
+		s.Attributes = s.Attributes.WithValue(diagnosticsKey{}, d)
+	}
+	return s
+}
+
+// diagnosticsFrom returns the resolver state diagnostics, if any.
+func diagnosticsFrom(s resolver.State) *Diagnostics {
+	d, _ := s.Attributes.Value(diagnosticsKey{}).(*Diagnostics)
+	return d
+}
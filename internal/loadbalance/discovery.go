@@ -0,0 +1,365 @@
+package loadbalance
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+	"gopkg.in/yaml.v3"
+
+	api "github.com/comfforts/comff-geo/api/v1"
+)
+
+var (
+	ErrNoSeeds   = errors.New("no seed servers in target")
+	ErrNoServers = errors.New("no servers discovered")
+	ErrLeaderTie = errors.New("more than one leader discovered")
+)
+
+const defaultPollInterval = 5 * time.Second
+
+// Discovery provides the geo cluster members.
+type Discovery interface {
+	// Discover returns the current cluster members, with is_leader attributes.
+	Discover(ctx context.Context) ([]resolver.Address, error)
+	Close() error
+}
+
+// Watcher is implemented by discovery sources that signal changes,
+// so the resolver doesn't wait for the next refresh.
+type Watcher interface {
+	Changes() <-chan struct{}
+}
+
+// DiscoveryBuilder creates the discovery source for a resolver, given
+// the target seeds and the resolver connection dial options.
+type DiscoveryBuilder func(seeds []string, dialOpts []grpc.DialOption) (Discovery, error)
+
+// Server is a cluster member as listed by static and file discovery.
+type Server struct {
+	Addr     string `json:"addr" yaml:"addr"`
+	IsLeader bool   `json:"is_leader" yaml:"is_leader"`
+	Zone     string `json:"zone,omitempty" yaml:"zone,omitempty"`
+	Region   string `json:"region,omitempty" yaml:"region,omitempty"`
+}
+
+func (s Server) address() resolver.Address {
+	return withLocality(resolver.Address{
+		Addr: s.Addr,
+		Attributes: attributes.New(
+			"is_leader",
+			s.IsLeader,
+		),
+	}, Locality{Zone: s.Zone, Region: s.Region})
+}
+
+func addresses(servers []Server) []resolver.Address {
+	var addrs []resolver.Address
+	for _, server := range servers {
+		addrs = append(addrs, server.address())
+	}
+	return addrs
+}
+
+var _ Discovery = (*serversDiscovery)(nil)
+
+// serversDiscovery gets the cluster members from the GetServers RPC,
+// asking all seeds and using the first answer listing servers.
+type serversDiscovery struct {
+	conns []*grpc.ClientConn
+}
+
+// NewServersDiscovery dials the seeds for the GetServers RPC.
+func NewServersDiscovery(seeds []string, dialOpts []grpc.DialOption) (Discovery, error) {
+	if len(seeds) == 0 {
+		return nil, ErrNoSeeds
+	}
+	d := &serversDiscovery{}
+	for _, seed := range seeds {
+		conn, err := grpc.Dial(seed, dialOpts...)
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		d.conns = append(d.conns, conn)
+	}
+	return d, nil
+}
+
+func (d *serversDiscovery) Discover(ctx context.Context) ([]resolver.Address, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		seed string
+		res  *api.GetServersResponse
+		err  error
+	}
+	results := make(chan result, len(d.conns))
+	for _, conn := range d.conns {
+		go func(conn *grpc.ClientConn) {
+			client := api.NewGeoClient(conn)
+			res, err := client.GetServers(ctx, &api.GetServersRequest{})
+			if err != nil {
+				err = fmt.Errorf("seed %s: %w", conn.Target(), err)
+			}
+			results <- result{seed: conn.Target(), res: res, err: err}
+		}(conn)
+	}
+
+	var errs []error
+	for range d.conns {
+		r := <-results
+		if r.err != nil {
+			errs = append(errs, r.err)
+			continue
+		}
+		if len(r.res.Servers) == 0 {
+			// e.g. a restarted seed that hasn't joined the cluster yet
+			errs = append(errs, fmt.Errorf("seed %s: %w", r.seed, ErrNoServers))
+			continue
+		}
+		var addrs []resolver.Address
+		for _, server := range r.res.Servers {
+			addrs = append(addrs, Server{
+				Addr:     server.Addr,
+				IsLeader: server.IsLeader,
+			}.address())
+		}
+		return addrs, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (d *serversDiscovery) Close() error {
+	var errs []error
+	for _, conn := range d.conns {
+		if err := conn.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var _ Discovery = (*staticDiscovery)(nil)
+
+// staticDiscovery serves a fixed server list.
+type staticDiscovery struct {
+	addrs []resolver.Address
+}
+
+// StaticDiscovery returns a discovery builder for a fixed server list.
+func StaticDiscovery(servers ...Server) DiscoveryBuilder {
+	return func([]string, []grpc.DialOption) (Discovery, error) {
+		if len(servers) == 0 {
+			return nil, ErrNoServers
+		}
+		return &staticDiscovery{addrs: addresses(servers)}, nil
+	}
+}
+
+func (d *staticDiscovery) Discover(context.Context) ([]resolver.Address, error) {
+	return d.addrs, nil
+}
+
+func (d *staticDiscovery) Close() error {
+	return nil
+}
+
+var _ Discovery = (*fileDiscovery)(nil)
+var _ Watcher = (*fileDiscovery)(nil)
+
+// fileDiscovery reads the server list from a JSON or YAML file,
+// polling it for changes.
+type fileDiscovery struct {
+	file    string
+	changes chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// FileDiscovery returns a discovery builder reading the server list from
+// a JSON, or .yaml/.yml, file, checked for changes every pollInterval.
+func FileDiscovery(file string, pollInterval time.Duration) DiscoveryBuilder {
+	return func([]string, []grpc.DialOption) (Discovery, error) {
+		if pollInterval <= 0 {
+			pollInterval = defaultPollInterval
+		}
+		d := &fileDiscovery{
+			file:    file,
+			changes: make(chan struct{}, 1),
+			done:    make(chan struct{}),
+		}
+		modTime, size := d.stat()
+		d.wg.Add(1)
+		go d.poll(pollInterval, modTime, size)
+		return d, nil
+	}
+}
+
+func (d *fileDiscovery) Discover(context.Context) ([]resolver.Address, error) {
+	servers, err := readServers(d.file)
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoServers, d.file)
+	}
+	return addresses(servers), nil
+}
+
+func (d *fileDiscovery) Changes() <-chan struct{} {
+	return d.changes
+}
+
+// poll signals a change when the file modification time or size
+// differs from the last seen.
+func (d *fileDiscovery) poll(interval time.Duration, modTime time.Time, size int64) {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			mt, sz := d.stat()
+			if mt.Equal(modTime) && sz == size {
+				continue
+			}
+			modTime, size = mt, sz
+			select {
+			case d.changes <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (d *fileDiscovery) stat() (time.Time, int64) {
+	fi, err := os.Stat(d.file)
+	if err != nil {
+		return time.Time{}, -1
+	}
+	return fi.ModTime(), fi.Size()
+}
+
+func (d *fileDiscovery) Close() error {
+	close(d.done)
+	d.wg.Wait()
+	return nil
+}
+
+var _ Discovery = (*dnsDiscovery)(nil)
+
+// dnsDiscovery looks up the servers from DNS SRV records. The record
+// with the lowest priority is the leader, when priorities differ. More
+// than one record with the lowest priority is an error, as the leader
+// would be arbitrary.
+type dnsDiscovery struct {
+	name     string
+	resolver SRVResolver
+}
+
+// SRVResolver looks up DNS SRV records sorted by priority, as
+// net.Resolver does.
+type SRVResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// DNSDiscovery returns a discovery builder looking up the SRV records
+// of name, e.g. _geo._tcp.geo.example.com.
+func DNSDiscovery(name string) DiscoveryBuilder {
+	return DNSResolverDiscovery(name, net.DefaultResolver)
+}
+
+// DNSResolverDiscovery returns a discovery builder looking up the SRV
+// records of name with the resolver.
+func DNSResolverDiscovery(name string, resolver SRVResolver) DiscoveryBuilder {
+	return func([]string, []grpc.DialOption) (Discovery, error) {
+		return &dnsDiscovery{
+			name:     name,
+			resolver: resolver,
+		}, nil
+	}
+}
+
+func (d *dnsDiscovery) Discover(ctx context.Context) ([]resolver.Address, error) {
+	_, srvs, err := d.resolver.LookupSRV(ctx, "", "", d.name)
+	if err != nil {
+		return nil, err
+	}
+	if len(srvs) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoServers, d.name)
+	}
+
+	// records are sorted by priority
+	leaderPriority := srvs[0].Priority
+	hasLeader := srvs[len(srvs)-1].Priority != leaderPriority
+	if hasLeader && srvs[1].Priority == leaderPriority {
+		return nil, fmt.Errorf("%w: %s priority %d", ErrLeaderTie, d.name, leaderPriority)
+	}
+
+	var servers []Server
+	for _, srv := range srvs {
+		host := strings.TrimSuffix(srv.Target, ".")
+		servers = append(servers, Server{
+			Addr:     net.JoinHostPort(host, strconv.Itoa(int(srv.Port))),
+			IsLeader: hasLeader && srv.Priority == leaderPriority,
+		})
+	}
+	return addresses(servers), nil
+}
+
+func (d *dnsDiscovery) Close() error {
+	return nil
+}
+
+// readServers reads a server list file, YAML for .yaml/.yml files,
+// JSON otherwise.
+func readServers(file string) ([]Server, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var servers []Server
+	switch filepath.Ext(file) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &servers)
+	default:
+		err = json.Unmarshal(b, &servers)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return servers, nil
+}
+
+// writeServers saves the server list as JSON, replacing the file atomically.
+func writeServers(file string, servers []Server) error {
+	b, err := json.Marshal(servers)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,150 @@
+package loadbalance_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/comfforts/comff-geo-client/internal/loadbalance"
+)
+
+func TestFileDiscovery(t *testing.T) {
+	for file, want := range map[string]int{
+		"testdata/servers.json": 2,
+		"testdata/servers.yaml": 3,
+	} {
+		d, err := loadbalance.FileDiscovery(file, time.Second)(nil, nil)
+		require.NoError(t, err)
+
+		addrs, err := d.Discover(context.Background())
+		require.NoError(t, err)
+		require.Len(t, addrs, want)
+		require.Equal(t, []bool{true, false}, leaders(addrs)[:2])
+		require.NoError(t, d.Close())
+	}
+}
+
+func TestFileDiscoveryChanges(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "servers.json")
+	writeFile(t, file, `[{"addr": "localhost:9001", "is_leader": true}]`)
+
+	cc := &clientConn{}
+	teardown := buildResolver(t, &loadbalance.Resolver{
+		Discovery: loadbalance.FileDiscovery(file, 10*time.Millisecond),
+	}, "", cc)
+	defer teardown()
+
+	require.Eventually(t, func() bool {
+		return cc.updates() == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Len(t, cc.state().Addresses, 1)
+
+	writeFile(t, file, `[
+		{"addr": "localhost:9001"},
+		{"addr": "localhost:9002", "is_leader": true}
+	]`)
+	require.Eventually(t, func() bool {
+		return cc.updates() == 2
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []bool{false, true}, leaders(cc.state().Addresses))
+}
+
+func TestStaticDiscovery(t *testing.T) {
+	cc := &clientConn{}
+	teardown := buildResolver(t, &loadbalance.Resolver{
+		Discovery: loadbalance.StaticDiscovery(
+			loadbalance.Server{Addr: "localhost:9001", IsLeader: true},
+			loadbalance.Server{Addr: "localhost:9002"},
+		),
+	}, "", cc)
+	defer teardown()
+
+	require.Eventually(t, func() bool {
+		return cc.updates() == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []bool{true, false}, leaders(cc.state().Addresses))
+}
+
+func TestDNSDiscovery(t *testing.T) {
+	srvs := srvResolver{
+		"_geo._tcp.leader.example.com": {
+			{Target: "geo-1.example.com.", Port: 9001, Priority: 0},
+			{Target: "geo-2.example.com.", Port: 9002, Priority: 10},
+			{Target: "geo-3.example.com.", Port: 9003, Priority: 10},
+		},
+		"_geo._tcp.no-leader.example.com": {
+			{Target: "geo-1.example.com.", Port: 9001, Priority: 10},
+			{Target: "geo-2.example.com.", Port: 9002, Priority: 10},
+		},
+		"_geo._tcp.leader-tie.example.com": {
+			{Target: "geo-1.example.com.", Port: 9001, Priority: 0},
+			{Target: "geo-2.example.com.", Port: 9002, Priority: 0},
+			{Target: "geo-3.example.com.", Port: 9003, Priority: 10},
+		},
+		"_geo._tcp.empty.example.com": {},
+	}
+	discover := func(name string) ([]resolver.Address, error) {
+		d, err := loadbalance.DNSResolverDiscovery(name, srvs)(nil, nil)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, d.Close())
+		}()
+		return d.Discover(context.Background())
+	}
+
+	// lowest priority records are the leader
+	addrs, err := discover("_geo._tcp.leader.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "geo-1.example.com:9001", addrs[0].Addr)
+	require.Equal(t, []bool{true, false, false}, leaders(addrs))
+
+	// same priority records are all followers
+	addrs, err = discover("_geo._tcp.no-leader.example.com")
+	require.NoError(t, err)
+	require.Equal(t, "geo-2.example.com:9002", addrs[1].Addr)
+	require.Equal(t, []bool{false, false}, leaders(addrs))
+
+	// tied lowest priority records leave the leader arbitrary
+	_, err = discover("_geo._tcp.leader-tie.example.com")
+	require.ErrorIs(t, err, loadbalance.ErrLeaderTie)
+
+	_, err = discover("_geo._tcp.empty.example.com")
+	require.ErrorIs(t, err, loadbalance.ErrNoServers)
+	_, err = discover("_geo._tcp.unknown.example.com")
+	var dnsErr *net.DNSError
+	require.ErrorAs(t, err, &dnsErr)
+}
+
+// srvResolver serves the SRV records keyed on their name.
+type srvResolver map[string][]*net.SRV
+
+func (r srvResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	srvs, ok := r[name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return name, srvs, nil
+}
+
+func leaders(addrs []resolver.Address) []bool {
+	var isLeader []bool
+	for _, addr := range addrs {
+		isLeader = append(isLeader, addr.Attributes.Value("is_leader").(bool))
+	}
+	return isLeader
+}
+
+func writeFile(t *testing.T, file, content string) {
+	t.Helper()
+
+	// replace atomically so discovery never reads a partial file
+	tmp := file + ".tmp"
+	require.NoError(t, os.WriteFile(tmp, []byte(content), 0644))
+	require.NoError(t, os.Rename(tmp, file))
+}
@@ -0,0 +1,80 @@
+package loadbalance
+
+import (
+	"google.golang.org/grpc/resolver"
+)
+
+// EventType is the kind of cluster membership change.
+type EventType int
+
+const (
+	LeaderElected EventType = iota + 1
+	LeaderLost
+	FollowerAdded
+	FollowerRemoved
+)
+
+func (t EventType) String() string {
+	switch t {
+	case LeaderElected:
+		return "leader-elected"
+	case LeaderLost:
+		return "leader-lost"
+	case FollowerAdded:
+		return "follower-added"
+	case FollowerRemoved:
+		return "follower-removed"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is a cluster membership change between successive server lists.
+type Event struct {
+	Type EventType
+	Addr string
+}
+
+// membershipEvents returns the events turning the prev server list into next.
+// A follower becoming leader is removed as a follower and elected as leader.
+func membershipEvents(prev, next []resolver.Address) []Event {
+	prevLeader, prevFollowers := members(prev)
+	nextLeader, nextFollowers := members(next)
+
+	var events []Event
+	if prevLeader != "" && prevLeader != nextLeader {
+		events = append(events, Event{Type: LeaderLost, Addr: prevLeader})
+	}
+	for _, addr := range prev {
+		if _, ok := prevFollowers[addr.Addr]; !ok {
+			continue
+		}
+		if _, ok := nextFollowers[addr.Addr]; !ok {
+			events = append(events, Event{Type: FollowerRemoved, Addr: addr.Addr})
+		}
+	}
+	if nextLeader != "" && nextLeader != prevLeader {
+		events = append(events, Event{Type: LeaderElected, Addr: nextLeader})
+	}
+	for _, addr := range next {
+		if _, ok := nextFollowers[addr.Addr]; !ok {
+			continue
+		}
+		if _, ok := prevFollowers[addr.Addr]; !ok {
+			events = append(events, Event{Type: FollowerAdded, Addr: addr.Addr})
+		}
+	}
+	return events
+}
+
+func members(addrs []resolver.Address) (leader string, followers map[string]struct{}) {
+	followers = make(map[string]struct{}, len(addrs))
+	for _, addr := range addrs {
+		if isLeader(addr) {
+			leader = addr.Addr
+			continue
+		}
+		followers[addr.Addr] = struct{}{}
+	}
+	return leader, followers
+}
@@ -0,0 +1,8 @@
+package loadbalance
+
+import "time"
+
+// SetClock sets the clock measuring the request latency.
+func SetClock(l *Load, now func() time.Time) {
+	l.now = now
+}
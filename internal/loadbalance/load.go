@@ -0,0 +1,129 @@
+package loadbalance
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+)
+
+// latencyAlpha is the EWMA smoothing factor of the request latency.
+const latencyAlpha = 0.3
+
+// Load tracks the in-flight requests, the moving average latency and
+// the outlier ejections per SubConn. It's kept by the balancer across picker rebuilds.
+// The zero value is ready to use.
+type Load struct {
+	stats sync.Map // balancer.SubConn -> *subConnLoad
+	now   func() time.Time
+}
+
+// subConnLoad is the load of a SubConn.
+type subConnLoad struct {
+	inflight int64
+
+	mu      sync.Mutex
+	latency float64 // EWMA, in nanoseconds
+	sampled bool
+	outlier outlier
+}
+
+func (l *Load) load(sc balancer.SubConn) *subConnLoad {
+	if s, ok := l.stats.Load(sc); ok {
+		return s.(*subConnLoad)
+	}
+	s, _ := l.stats.LoadOrStore(sc, &subConnLoad{})
+	return s.(*subConnLoad)
+}
+
+// Inflight returns the requests in flight on the SubConn.
+func (l *Load) Inflight(sc balancer.SubConn) int64 {
+	if s, ok := l.stats.Load(sc); ok {
+		return atomic.LoadInt64(&s.(*subConnLoad).inflight)
+	}
+	return 0
+}
+
+// Latency returns the moving average latency of the SubConn, and
+// whether it has been sampled.
+func (l *Load) Latency(sc balancer.SubConn) (time.Duration, bool) {
+	s, ok := l.stats.Load(sc)
+	if !ok {
+		return 0, false
+	}
+	sl := s.(*subConnLoad)
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return time.Duration(sl.latency), sl.sampled
+}
+
+// cost estimates the latency of one more request on the SubConn, its
+// average latency times the requests it would have in flight. SubConns
+// never sampled are expected at the unsampled latency.
+func (l *Load) cost(sc balancer.SubConn, unsampled float64) float64 {
+	latency, ok := l.Latency(sc)
+	cost := float64(latency)
+	if !ok {
+		cost = unsampled
+	}
+	return cost * float64(l.Inflight(sc)+1)
+}
+
+// meanLatency returns the mean average latency of the sampled SubConns,
+// or 1ns when none is, so their costs still count the requests in flight.
+func (l *Load) meanLatency(scs []balancer.SubConn) float64 {
+	var sum float64
+	var sampled int
+	for _, sc := range scs {
+		if latency, ok := l.Latency(sc); ok {
+			sum += float64(latency)
+			sampled++
+		}
+	}
+	if sampled == 0 || sum == 0 {
+		return 1
+	}
+	return sum / float64(sampled)
+}
+
+// start counts a request on the SubConn, until the returned done is
+// called, which also records its latency, and its result for outlier
+// ejection when set.
+func (l *Load) start(sc balancer.SubConn, ejection *OutlierEjection) func(balancer.DoneInfo) {
+	s := l.load(sc)
+	atomic.AddInt64(&s.inflight, 1)
+	start := l.clock()
+	return func(info balancer.DoneInfo) {
+		atomic.AddInt64(&s.inflight, -1)
+		now := l.clock()
+		s.observe(now.Sub(start))
+		if ejection != nil {
+			s.observeResult(info.Err, *ejection, now)
+		}
+	}
+}
+
+func (s *subConnLoad) observe(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.sampled {
+		s.latency = float64(latency)
+		s.sampled = true
+		return
+	}
+	s.latency = latencyAlpha*float64(latency) + (1-latencyAlpha)*s.latency
+}
+
+func (l *Load) clock() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+// remove drops the load of the SubConn. The load, and its outlier
+// ejection, outlives the SubConn reconnecting, until it's removed.
+func (l *Load) remove(sc balancer.SubConn) {
+	l.stats.Delete(sc)
+}
@@ -0,0 +1,76 @@
+package loadbalance
+
+import (
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+)
+
+// Locality is the zone and region of a server or the client.
+type Locality struct {
+	Zone   string `json:"zone,omitempty"`
+	Region string `json:"region,omitempty"`
+}
+
+// localityOf returns the address zone and region attributes.
+func localityOf(addr resolver.Address) Locality {
+	zone, _ := addr.Attributes.Value("zone").(string)
+	region, _ := addr.Attributes.Value("region").(string)
+	return Locality{Zone: zone, Region: region}
+}
+
+// withLocality sets the address zone and region attributes, when known.
+func withLocality(addr resolver.Address, l Locality) resolver.Address {
+	if l.Zone != "" {
+		addr.Attributes = addr.Attributes.WithValue("zone", l.Zone)
+	}
+	if l.Region != "" {
+		addr.Attributes = addr.Attributes.WithValue("region", l.Region)
+	}
+	return addr
+}
+
+// assignLocalities sets the locality of the addresses without one from
+// the static mapping, keyed on the address.
+func assignLocalities(addrs []resolver.Address, localities map[string]Locality) []resolver.Address {
+	if len(localities) == 0 {
+		return addrs
+	}
+	assigned := make([]resolver.Address, 0, len(addrs))
+	for _, addr := range addrs {
+		if l, ok := localities[addr.Addr]; ok && localityOf(addr) == (Locality{}) {
+			addr = withLocality(addr, l)
+		}
+		assigned = append(assigned, addr)
+	}
+	return assigned
+}
+
+// localReaders returns the followers in the client zone, else in the
+// client region, else all of them.
+func localReaders(followers []balancer.SubConn, localities map[balancer.SubConn]Locality, client Locality) []balancer.SubConn {
+	if client.Zone != "" {
+		if local := filterLocality(followers, func(sc balancer.SubConn) bool {
+			return localities[sc].Zone == client.Zone
+		}); len(local) > 0 {
+			return local
+		}
+	}
+	if client.Region != "" {
+		if local := filterLocality(followers, func(sc balancer.SubConn) bool {
+			return localities[sc].Region == client.Region
+		}); len(local) > 0 {
+			return local
+		}
+	}
+	return followers
+}
+
+func filterLocality(followers []balancer.SubConn, match func(balancer.SubConn) bool) []balancer.SubConn {
+	var local []balancer.SubConn
+	for _, sc := range followers {
+		if match(sc) {
+			local = append(local, sc)
+		}
+	}
+	return local
+}
@@ -0,0 +1,201 @@
+package loadbalance
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var ErrInvalidEjection = errors.New("invalid outlier ejection")
+
+const (
+	defaultEjectionErrorRate   = 0.5
+	defaultEjectionMinRequests = 10
+	defaultBaseEjectionTime    = 30 * time.Second
+	defaultMaxEjectionTime     = 5 * time.Minute
+	defaultRecoveryTime        = 30 * time.Second
+
+	// errorRateAlpha is the EWMA smoothing factor of the error rate.
+	errorRateAlpha = 0.2
+	// minRecoveryShare is the share of reads a follower gets right
+	// after its ejection ends.
+	minRecoveryShare = 0.1
+)
+
+// Duration is a time.Duration set as a string in the JSON config, e.g. "30s".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// OutlierEjection ejects the followers whose moving average rate of
+// Unavailable and DeadlineExceeded errors exceeds ErrorRate. Ejected
+// followers get no reads for the ejection time, doubling with each
+// ejection in a row up to MaxEjectionTime, then get their share of
+// reads back gradually over RecoveryTime.
+type OutlierEjection struct {
+	// ErrorRate ejects a follower above this error rate, 0.5 by default.
+	ErrorRate float64 `json:"errorRate,omitempty"`
+	// MinRequests is the requests a follower serves before it can be
+	// ejected, 10 by default.
+	MinRequests int `json:"minRequests,omitempty"`
+	// BaseEjectionTime is the first ejection time, 30s by default.
+	BaseEjectionTime Duration `json:"baseEjectionTime,omitempty"`
+	// MaxEjectionTime caps the ejection time, 5m by default.
+	MaxEjectionTime Duration `json:"maxEjectionTime,omitempty"`
+	// RecoveryTime ramps up the reads of a returning follower, 30s by default.
+	RecoveryTime Duration `json:"recoveryTime,omitempty"`
+}
+
+func (o *OutlierEjection) validate() error {
+	if o.ErrorRate < 0 || o.ErrorRate > 1 {
+		return fmt.Errorf("%w: error rate %v", ErrInvalidEjection, o.ErrorRate)
+	}
+	if o.MinRequests < 0 || o.BaseEjectionTime < 0 || o.MaxEjectionTime < 0 || o.RecoveryTime < 0 {
+		return fmt.Errorf("%w: negative value", ErrInvalidEjection)
+	}
+	return nil
+}
+
+// withDefaults returns the config with the defaults for the unset values.
+func (o OutlierEjection) withDefaults() OutlierEjection {
+	if o.ErrorRate == 0 {
+		o.ErrorRate = defaultEjectionErrorRate
+	}
+	if o.MinRequests == 0 {
+		o.MinRequests = defaultEjectionMinRequests
+	}
+	if o.BaseEjectionTime == 0 {
+		o.BaseEjectionTime = Duration(defaultBaseEjectionTime)
+	}
+	if o.MaxEjectionTime == 0 {
+		o.MaxEjectionTime = Duration(defaultMaxEjectionTime)
+	}
+	if o.RecoveryTime == 0 {
+		o.RecoveryTime = Duration(defaultRecoveryTime)
+	}
+	return o
+}
+
+// outlier is the ejection state of a SubConn.
+type outlier struct {
+	errorRate    float64
+	requests     int
+	ejections    int
+	ejectedUntil time.Time
+}
+
+// isOutlierError reports whether the request error counts toward ejection.
+func isOutlierError(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+// observeResult updates the SubConn error rate with the request result,
+// ejecting it above the error rate.
+func (s *subConnLoad) observeResult(err error, cfg OutlierEjection, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := &s.outlier
+	if now.Before(o.ejectedUntil) {
+		return
+	}
+	failed := 0.0
+	if isOutlierError(err) {
+		failed = 1
+	}
+	o.errorRate = errorRateAlpha*failed + (1-errorRateAlpha)*o.errorRate
+	o.requests++
+	if o.requests < cfg.MinRequests || o.errorRate <= cfg.ErrorRate {
+		return
+	}
+
+	// ejections in a row back off, a recovered SubConn starts over
+	recovered := now.Sub(o.ejectedUntil) > time.Duration(cfg.RecoveryTime)
+	if o.ejections == 0 || recovered {
+		o.ejections = 1
+	} else {
+		o.ejections++
+	}
+	ejection := time.Duration(cfg.BaseEjectionTime)
+	for i := 1; i < o.ejections && ejection < time.Duration(cfg.MaxEjectionTime); i++ {
+		ejection *= 2
+	}
+	if ejection > time.Duration(cfg.MaxEjectionTime) {
+		ejection = time.Duration(cfg.MaxEjectionTime)
+	}
+	o.ejectedUntil = now.Add(ejection)
+	o.errorRate = 0
+	o.requests = 0
+}
+
+// available reports whether the SubConn may be picked: not ejected,
+// and while recovering, for a share of the picks growing over the
+// recovery time.
+func (s *subConnLoad) available(cfg OutlierEjection, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	o := &s.outlier
+	if o.ejectedUntil.IsZero() {
+		return true
+	}
+	if now.Before(o.ejectedUntil) {
+		return false
+	}
+	recovery := time.Duration(cfg.RecoveryTime)
+	since := now.Sub(o.ejectedUntil)
+	if since >= recovery {
+		return true
+	}
+	share := minRecoveryShare + (1-minRecoveryShare)*float64(since)/float64(recovery)
+	return rand.Float64() < share
+}
+
+// Ejected reports whether the SubConn is ejected as an outlier.
+func (l *Load) Ejected(sc balancer.SubConn) bool {
+	s, ok := l.stats.Load(sc)
+	if !ok {
+		return false
+	}
+	sl := s.(*subConnLoad)
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	return l.clock().Before(sl.outlier.ejectedUntil)
+}
+
+// available returns the SubConns not ejected as outliers.
+func (l *Load) available(scs []balancer.SubConn, cfg OutlierEjection) []balancer.SubConn {
+	now := l.clock()
+	available := make([]balancer.SubConn, 0, len(scs))
+	for _, sc := range scs {
+		if l.load(sc).available(cfg, now) {
+			available = append(available, sc)
+		}
+	}
+	return available
+}
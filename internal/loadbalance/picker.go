@@ -2,10 +2,11 @@ package loadbalance
 
 import (
 	"fmt"
-	"strings"
+	"math/rand"
 	"sync"
 	"sync/atomic"
 
+	"go.uber.org/zap"
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
 )
@@ -14,30 +15,84 @@ var _ base.PickerBuilder = (*Picker)(nil)
 var _ balancer.Picker = (*Picker)(nil)
 
 type Picker struct {
+	// Config overrides the default routing, when set.
+	Config *Config
+	// Load tracks the requests in flight, shared across rebuilds, the
+	// balancer drops the load of the removed SubConns. Build sets a new
+	// one when nil.
+	Load *Load
+	// LeaderResolved is set when the resolved servers include a leader,
+	// writes then wait for it to be ready instead of failing with ErrNoLeader.
+	LeaderResolved bool
+	// LeaderFailed is set when the resolved leader is in transient
+	// failure, writes then fail with ErrNoLeader instead of waiting.
+	LeaderFailed bool
+	// Logger logs the picks at debug level, the global zap logger
+	// when not set.
+	Logger *zap.Logger
+	// OnPick, when set, is called with every pick decision.
+	OnPick func(PickTrace)
+
 	mu        sync.RWMutex
 	leader    balancer.SubConn
 	followers []balancer.SubConn
+	readers   []balancer.SubConn
+	all       []balancer.SubConn
+	subConns  map[string]balancer.SubConn
+	addrs     map[balancer.SubConn]string
+	ejection  *OutlierEjection
 	current   uint64
 }
 
-func init() {
-	balancer.Register(base.NewBalancerBuilder(GeoCQRSResolverName, &Picker{}, base.Config{}))
-}
-
 func (p *Picker) Build(buildInfo base.PickerBuildInfo) balancer.Picker {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	if p.Load == nil {
+		p.Load = &Load{}
+	}
+	if p.Logger == nil {
+		p.Logger = zap.L().Named(fmt.Sprintf("%s-picker", GeoCQRSResolverName))
+	}
+
+	p.leader = nil
 	var followers []balancer.SubConn
+	localities := make(map[balancer.SubConn]Locality, len(buildInfo.ReadySCs))
+	p.subConns = make(map[string]balancer.SubConn, len(buildInfo.ReadySCs))
+	p.addrs = make(map[balancer.SubConn]string, len(buildInfo.ReadySCs))
 	for sc, scInfo := range buildInfo.ReadySCs {
-		isLeader := scInfo.Address.Attributes.Value("is_leader").(bool)
-		if isLeader {
+		p.addrs[sc] = scInfo.Address.Addr
+		localities[sc] = localityOf(scInfo.Address)
+		p.subConns[scInfo.Address.Addr] = sc
+		if isLeader(scInfo.Address) {
 			p.leader = sc
 			continue
 		}
 		followers = append(followers, sc)
 	}
 	p.followers = followers
+	p.all = followers
+	if p.leader != nil {
+		p.all = append([]balancer.SubConn{p.leader}, followers...)
+	}
+	p.readers = followers
+	if p.Config != nil {
+		p.readers = localReaders(followers, localities, p.Config.Locality)
+	}
+	if p.Config != nil && p.Config.ReadFromLeader && p.leader != nil {
+		p.readers = append([]balancer.SubConn{p.leader}, p.readers...)
+	}
+	p.ejection = nil
+	if p.Config != nil && p.Config.OutlierEjection != nil {
+		ejection := p.Config.OutlierEjection.withDefaults()
+		p.ejection = &ejection
+	}
+	p.Logger.Debug(
+		"picker built",
+		zap.String("leader", p.addrs[p.leader]),
+		zap.Int("followers", len(p.followers)),
+		zap.Int("readers", len(p.readers)),
+	)
 	return p
 }
 
@@ -45,64 +100,235 @@ func (p *Picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 
-	fmt.Printf("picking connection for: %s, curr followers: %d\n", info.FullMethodName, len(p.followers))
+	result, route, reason, err := p.pick(info)
+	if p.OnPick != nil || p.logger().Core().Enabled(zap.DebugLevel) {
+		p.trace(PickTrace{
+			Method: info.FullMethodName,
+			Route:  route,
+			Addr:   p.addrs[result.SubConn],
+			Reason: reason,
+			Err:    err,
+		})
+	}
+	if err != nil {
+		return result, err
+	}
+	if attempts := AttemptsFromContext(info.Ctx); attempts != nil {
+		attempts.add(result.SubConn)
+	}
+	result.Done = p.Load.start(result.SubConn, p.ejection)
+	return result, nil
+}
 
-	var result balancer.PickResult
+// pick picks the SubConn for the request, returning its route and
+// the reason for the decision.
+func (p *Picker) pick(info balancer.PickInfo) (result balancer.PickResult, route Route, reason string, err error) {
+	// pinned server
+	if addr, ok := ServerFromContext(info.Ctx); ok {
+		result.SubConn = p.subConns[addr]
+		if result.SubConn == nil {
+			return result, "", "pinned server", fmt.Errorf("%w: %s", ErrServerNotReady, addr)
+		}
+		return result, "", "pinned server", nil
+	}
 
+	route, ok := RouteFromContext(info.Ctx)
+	if !ok {
+		route = p.route(info.FullMethodName)
+	}
+	if route == RouteLeader && p.leader == nil && (p.LeaderFailed || (len(p.subConns) > 0 && !p.LeaderResolved)) {
+		return result, route, "no leader", ErrNoLeader
+	}
 	if len(p.followers) == 0 {
-		fmt.Println("no followers, picking leader")
+		reason = "no followers, leader"
 		result.SubConn = p.leader
-	} else if p.isWriteRequest(info) {
-		fmt.Println("is write request, picking leader")
+	} else if route == RouteLeader {
+		reason = "leader"
 		result.SubConn = p.leader
-	} else if p.isReadRequest(info) {
-		fmt.Println("is read request, picking next follower")
-		result.SubConn = p.nextFollower()
-	} else if p.isCommonRequest(info) {
-		fmt.Println("is common request, picking current")
-		result.SubConn = p.getCurrent()
+	} else if route == RouteFollower {
+		readers, fallback := p.availableReaders()
+		if readers == nil {
+			reason = "followers ejected, " + fallback
+			result.SubConn = p.leader
+		} else {
+			reason = "follower " + string(p.selection())
+			if fallback != "" {
+				reason += ", " + fallback
+			}
+			if attempts := AttemptsFromContext(info.Ctx); attempts != nil {
+				readers = attempts.untried(readers)
+			}
+			result.SubConn = p.nextFollower(readers)
+		}
+	} else if route == RouteCommon {
+		reason = "common " + string(p.commonPolicy())
+		result.SubConn = p.nextCommon()
 	}
 	if result.SubConn == nil {
-		return result, balancer.ErrNoSubConnAvailable
+		return result, route, "not ready", balancer.ErrNoSubConnAvailable
+	}
+	return result, route, reason, nil
+}
+
+// trace logs the pick decision and passes it to OnPick.
+func (p *Picker) trace(t PickTrace) {
+	p.logger().Debug(
+		"pick",
+		zap.String("method", t.Method),
+		zap.String("route", string(t.Route)),
+		zap.String("addr", t.Addr),
+		zap.String("reason", t.Reason),
+		zap.Error(t.Err),
+	)
+	if p.OnPick != nil {
+		p.OnPick(t)
 	}
-	return result, nil
 }
 
-func (p *Picker) nextFollower() balancer.SubConn {
+func (p *Picker) logger() *zap.Logger {
+	if p.Logger == nil {
+		return zap.L()
+	}
+	return p.Logger
+}
+
+// route returns the method's route from the config overrides, the
+// routing table, or the default route for unknown methods.
+func (p *Picker) route(method string) Route {
+	if p.Config != nil {
+		if route, ok := p.Config.Routes[method]; ok {
+			return route
+		}
+	}
+	if route, ok := MethodRoute(method); ok {
+		if route == RouteCommon && p.Config != nil && p.Config.CommonRoute != "" {
+			return p.Config.CommonRoute
+		}
+		return route
+	}
+	if p.Config != nil && p.Config.DefaultRoute != "" {
+		return p.Config.DefaultRoute
+	}
+	return DefaultRoute
+}
+
+func (p *Picker) selection() Selection {
+	if p.Config == nil || p.Config.FollowerSelection == "" {
+		return SelectRoundRobin
+	}
+	return p.Config.FollowerSelection
+}
+
+// availableReaders returns the readers not ejected as outliers, else
+// the available followers of the other zones. It returns nil, falling
+// back to the leader, when every follower is ejected, unless there's no
+// leader to fall back to. fallback explains the readers used.
+func (p *Picker) availableReaders() (readers []balancer.SubConn, fallback string) {
+	if p.ejection == nil {
+		return p.readers, ""
+	}
+	if readers := p.Load.available(p.readers, *p.ejection); len(readers) > 0 {
+		return readers, ""
+	}
+	if readers := p.Load.available(p.followers, *p.ejection); len(readers) > 0 {
+		return readers, "local followers ejected"
+	}
+	if p.leader != nil {
+		return nil, "leader"
+	}
+	return p.readers, "all ejected"
+}
+
+func (p *Picker) nextFollower(readers []balancer.SubConn) balancer.SubConn {
+	switch p.selection() {
+	case SelectRandom:
+		return readers[rand.Intn(len(readers))]
+	case SelectLeastLoaded:
+		return p.leastLoaded(readers)
+	case SelectPowerOfTwo:
+		return p.powerOfTwo(readers)
+	case SelectLatency:
+		if rand.Float64() >= p.Config.latencyProbe() {
+			return p.fastest(readers)
+		}
+	}
 	cur := atomic.AddUint64(&p.current, uint64(1))
-	len := uint64(len(p.followers))
+	len := uint64(len(readers))
 	idx := int(cur % len)
-	return p.followers[idx]
+	return readers[idx]
 }
 
-func (p *Picker) getCurrent() balancer.SubConn {
-	curr := p.followers[p.current]
-	if curr == nil {
-		return p.leader
+// fastest returns the reader with the lowest expected latency, given
+// its moving average latency and requests in flight. Readers not
+// sampled yet are expected at the mean latency of the others.
+func (p *Picker) fastest(readers []balancer.SubConn) balancer.SubConn {
+	start := int(atomic.AddUint64(&p.current, uint64(1)) % uint64(len(readers)))
+	unsampled := p.Load.meanLatency(readers)
+	var best balancer.SubConn
+	var bestCost float64
+	for i := range readers {
+		sc := readers[(start+i)%len(readers)]
+		if cost := p.Load.cost(sc, unsampled); best == nil || cost < bestCost {
+			best, bestCost = sc, cost
+		}
+	}
+	return best
+}
+
+// leastLoaded returns the reader with the fewest requests in flight,
+// starting the scan at the next round robin index to spread ties.
+func (p *Picker) leastLoaded(readers []balancer.SubConn) balancer.SubConn {
+	start := int(atomic.AddUint64(&p.current, uint64(1)) % uint64(len(readers)))
+	var best balancer.SubConn
+	var bestLoad int64
+	for i := range readers {
+		sc := readers[(start+i)%len(readers)]
+		if load := p.Load.Inflight(sc); best == nil || load < bestLoad {
+			best, bestLoad = sc, load
+		}
 	}
-	return curr
+	return best
 }
 
-func (p *Picker) isWriteRequest(info balancer.PickInfo) bool {
-	return strings.Contains(info.FullMethodName, "AddGeoLocation") ||
-		strings.Contains(info.FullMethodName, "DeleteGeoLocation") ||
-		strings.Contains(info.FullMethodName, "AddAddress") ||
-		strings.Contains(info.FullMethodName, "UpdateAddress") ||
-		strings.Contains(info.FullMethodName, "DeleteAddress")
+// powerOfTwo returns the less loaded of two random readers.
+func (p *Picker) powerOfTwo(readers []balancer.SubConn) balancer.SubConn {
+	n := len(readers)
+	if n == 1 {
+		return readers[0]
+	}
+	i := rand.Intn(n)
+	j := rand.Intn(n - 1)
+	if j >= i {
+		j++
+	}
+	a, b := readers[i], readers[j]
+	if p.Load.Inflight(b) < p.Load.Inflight(a) {
+		return b
+	}
+	return a
 }
 
-func (p *Picker) isReadRequest(info balancer.PickInfo) bool {
-	return strings.Contains(info.FullMethodName, "GeoLocate") ||
-		strings.Contains(info.FullMethodName, "GetGeoRoute") ||
-		strings.Contains(info.FullMethodName, "GetAddressRoute") ||
-		strings.Contains(info.FullMethodName, "GetGeoLocation") ||
-		strings.Contains(info.FullMethodName, "GetGeoLocations") ||
-		strings.Contains(info.FullMethodName, "GetAddress") ||
-		strings.Contains(info.FullMethodName, "GetAddresses") ||
-		strings.Contains(info.FullMethodName, "GetAddressesByIds")
+func (p *Picker) commonPolicy() CommonPolicy {
+	if p.Config == nil || p.Config.CommonPolicy == "" {
+		return CommonAny
+	}
+	return p.Config.CommonPolicy
 }
 
-func (p *Picker) isCommonRequest(info balancer.PickInfo) bool {
-	return strings.Contains(info.FullMethodName, "GetServers") ||
-		strings.Contains(info.FullMethodName, "GetAddressTypes")
+// nextCommon picks the server for a common request, per the config
+// common policy.
+func (p *Picker) nextCommon() balancer.SubConn {
+	if len(p.all) == 0 {
+		return nil
+	}
+	switch p.commonPolicy() {
+	case CommonLeaderPreferred:
+		if p.leader != nil {
+			return p.leader
+		}
+		return p.all[int(atomic.AddUint64(&p.current, uint64(1))%uint64(len(p.all)))]
+	case CommonRoundRobin:
+		return p.all[int(atomic.AddUint64(&p.current, uint64(1))%uint64(len(p.all)))]
+	}
+	return p.all[int(atomic.LoadUint64(&p.current)%uint64(len(p.all)))]
 }
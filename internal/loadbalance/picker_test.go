@@ -1,14 +1,20 @@
 package loadbalance_test
 
 import (
+	"context"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 	"google.golang.org/grpc/attributes"
 	"google.golang.org/grpc/balancer"
 	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
 
 	"github.com/comfforts/comff-geo-client/internal/loadbalance"
 )
@@ -19,10 +25,10 @@ const TEST_DIR = "data"
 func TestPickerNoSubConnectionsErr(t *testing.T) {
 	picker := &loadbalance.Picker{}
 	for _, method := range []string{
-		"/geo.vX.Geo/AddGeoLocation",
-		"/geo.vX.Geo/AddGeoLocationLatLong",
-		"/geo.vX.Geo/AddGeoLocation",
-		"/geo.vX.Geo/AddGeoLocationLatLong",
+		"/geo.v1.Geo/AddGeoLocation",
+		"/geo.v1.Geo/AddGeoLocationLatLong",
+		"/geo.v1.Geo/AddGeoLocation",
+		"/geo.v1.Geo/AddGeoLocationLatLong",
 	} {
 		info := balancer.PickInfo{
 			FullMethodName: method,
@@ -36,7 +42,7 @@ func TestPickerNoSubConnectionsErr(t *testing.T) {
 func TestPickLeaderForAddAddress(t *testing.T) {
 	picker, subConns := setupTest()
 	info := balancer.PickInfo{
-		FullMethodName: "/geo.vX.Geo/AddAddress",
+		FullMethodName: "/geo.v1.Geo/AddAddress",
 	}
 	for i := 0; i < 5; i++ {
 		gotPick, err := picker.Pick(info)
@@ -48,7 +54,7 @@ func TestPickLeaderForAddAddress(t *testing.T) {
 func TestPickFollowerForGetAddress(t *testing.T) {
 	picker, subConns := setupTest()
 	info := balancer.PickInfo{
-		FullMethodName: "/geo.vX.Geo/GetAddress",
+		FullMethodName: "/geo.v1.Geo/GetAddress",
 	}
 	for i := 0; i < 5; i++ {
 		pick, err := picker.Pick(info)
@@ -57,6 +63,608 @@ func TestPickFollowerForGetAddress(t *testing.T) {
 	}
 }
 
+func TestPickRoutesExactMethods(t *testing.T) {
+	picker, subConns := setupTest()
+	for method, toLeader := range map[string]bool{
+		"/geo.v1.Geo/AddGeoLocation":        true,
+		"/geo.v1.Geo/AddGeoLocationLatLong": true,
+		"/geo.v1.Geo/GetAddress":            false,
+		"/geo.v1.Geo/GetAddressRoute":       false,
+		"/geo.v1.Geo/GetAddressesByIds":     false,
+		"/geo.v1.Geo/Unknown":               true,
+		"/geo.v2.Geo/GetAddress":            true,
+	} {
+		pick, err := picker.Pick(balancer.PickInfo{FullMethodName: method})
+		require.NoError(t, err)
+		require.Equal(t, toLeader, subConns[0] == pick.SubConn, method)
+	}
+}
+
+func TestPickConfigRoutes(t *testing.T) {
+	parser := balancer.Get(loadbalance.GeoCQRSResolverName).(balancer.ConfigParser)
+	cfg, err := parser.ParseConfig([]byte(`{
+		"defaultRoute": "follower",
+		"routes": {"/geo.v1.Geo/GetAddress": "leader"}
+	}`))
+	require.NoError(t, err)
+
+	picker, subConns := setupConfigTest(cfg.(*loadbalance.Config))
+	for method, toLeader := range map[string]bool{
+		"/geo.v1.Geo/GetAddress":    true,
+		"/geo.v1.Geo/GetAddresses":  false,
+		"/geo.v1.Geo/AddAddress":    true,
+		"/geo.v1.Geo/UnknownMethod": false,
+	} {
+		pick, err := picker.Pick(balancer.PickInfo{FullMethodName: method})
+		require.NoError(t, err)
+		require.Equal(t, toLeader, subConns[0] == pick.SubConn, method)
+	}
+
+	_, err = parser.ParseConfig([]byte(`{"routes": {"/geo.v1.Geo/GetAddress": "nearest"}}`))
+	require.ErrorIs(t, err, loadbalance.ErrInvalidRoute)
+}
+
+func TestPickConfigReads(t *testing.T) {
+	cfg, err := loadbalance.ParseConfig([]byte(`{
+		"followerSelection": "random",
+		"readFromLeader": true,
+		"commonRoute": "leader"
+	}`))
+	require.NoError(t, err)
+
+	picker, subConns := setupConfigTest(cfg)
+	picked := map[balancer.SubConn]bool{}
+	for i := 0; i < 100; i++ {
+		pick, err := picker.Pick(balancer.PickInfo{FullMethodName: "/geo.v1.Geo/GeoLocate"})
+		require.NoError(t, err)
+		picked[pick.SubConn] = true
+	}
+	require.Len(t, picked, 3)
+
+	for i := 0; i < 5; i++ {
+		pick, err := picker.Pick(balancer.PickInfo{FullMethodName: "/geo.v1.Geo/GetServers"})
+		require.NoError(t, err)
+		require.Equal(t, subConns[0], pick.SubConn)
+	}
+
+	_, err = loadbalance.ParseConfig([]byte(`{"followerSelection": "fastest"}`))
+	require.ErrorIs(t, err, loadbalance.ErrInvalidSelection)
+}
+
+func TestPickContextRoute(t *testing.T) {
+	picker, subConns := setupTest()
+	ctx := loadbalance.WithRoute(context.Background(), loadbalance.RouteLeader)
+	for i := 0; i < 5; i++ {
+		pick, err := picker.Pick(balancer.PickInfo{
+			FullMethodName: "/geo.v1.Geo/GetAddress",
+			Ctx:            ctx,
+		})
+		require.NoError(t, err)
+		require.Equal(t, subConns[0], pick.SubConn)
+	}
+}
+
+func TestPickNoLeader(t *testing.T) {
+	picker, subConns := setupTest()
+
+	// leader left
+	rebuild(picker, subConns[1:]...)
+	_, err := picker.Pick(balancer.PickInfo{
+		FullMethodName: "/geo.v1.Geo/AddAddress",
+	})
+	require.ErrorIs(t, err, loadbalance.ErrNoLeader)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	pick, err := picker.Pick(balancer.PickInfo{
+		FullMethodName: "/geo.v1.Geo/GetAddress",
+	})
+	require.NoError(t, err)
+	require.NotEqual(t, subConns[0], pick.SubConn)
+
+	// leader resolved but not ready yet
+	picker.LeaderResolved = true
+	_, err = picker.Pick(balancer.PickInfo{
+		FullMethodName: "/geo.v1.Geo/AddAddress",
+	})
+	require.Equal(t, balancer.ErrNoSubConnAvailable, err)
+
+	// leader resolved but in transient failure
+	picker.LeaderFailed = true
+	_, err = picker.Pick(balancer.PickInfo{
+		FullMethodName: "/geo.v1.Geo/AddAddress",
+	})
+	require.ErrorIs(t, err, loadbalance.ErrNoLeader)
+	rebuild(picker)
+	_, err = picker.Pick(balancer.PickInfo{
+		FullMethodName: "/geo.v1.Geo/AddAddress",
+	})
+	require.ErrorIs(t, err, loadbalance.ErrNoLeader)
+}
+
+func TestPickCommonPolicies(t *testing.T) {
+	for policy, check := range map[loadbalance.CommonPolicy]func(t *testing.T, picks map[balancer.SubConn]int, subConns []*subConn){
+		"": func(t *testing.T, picks map[balancer.SubConn]int, subConns []*subConn) {
+			require.Len(t, picks, 1)
+		},
+		loadbalance.CommonAny: func(t *testing.T, picks map[balancer.SubConn]int, subConns []*subConn) {
+			require.Len(t, picks, 1)
+		},
+		loadbalance.CommonLeaderPreferred: func(t *testing.T, picks map[balancer.SubConn]int, subConns []*subConn) {
+			require.Equal(t, map[balancer.SubConn]int{subConns[0]: 999}, picks)
+		},
+		loadbalance.CommonRoundRobin: func(t *testing.T, picks map[balancer.SubConn]int, subConns []*subConn) {
+			require.Equal(t, map[balancer.SubConn]int{
+				subConns[0]: 333,
+				subConns[1]: 333,
+				subConns[2]: 333,
+			}, picks)
+		},
+	} {
+		t.Run(string(policy), func(t *testing.T) {
+			picker, subConns := setupConfigTest(&loadbalance.Config{CommonPolicy: policy})
+			picks := make(map[balancer.SubConn]int)
+			for i := 0; i < 999; i++ {
+				pick, err := picker.Pick(balancer.PickInfo{
+					FullMethodName: "/geo.v1.Geo/GetServers",
+				})
+				require.NoError(t, err)
+				picks[pick.SubConn]++
+			}
+			check(t, picks, subConns)
+		})
+	}
+}
+
+func TestPickCommonFollowerChanges(t *testing.T) {
+	for _, policy := range []loadbalance.CommonPolicy{
+		loadbalance.CommonAny,
+		loadbalance.CommonLeaderPreferred,
+		loadbalance.CommonRoundRobin,
+	} {
+		t.Run(string(policy), func(t *testing.T) {
+			picker, subConns := setupConfigTest(&loadbalance.Config{CommonPolicy: policy})
+			for _, ready := range [][]*subConn{
+				subConns,
+				subConns[:1],
+				subConns[1:],
+				subConns[2:],
+				subConns,
+			} {
+				rebuild(picker, ready...)
+				for i := 0; i < 100; i++ {
+					for _, method := range []string{
+						"/geo.v1.Geo/GetServers",
+						"/geo.v1.Geo/GetAddressTypes",
+						"/geo.v1.Geo/GetAddress",
+					} {
+						pick, err := picker.Pick(balancer.PickInfo{
+							FullMethodName: method,
+						})
+						require.NoError(t, err)
+						require.Contains(t, ready, pick.SubConn)
+					}
+				}
+			}
+		})
+	}
+
+	// leader preferred falls back to the followers
+	picker, subConns := setupConfigTest(&loadbalance.Config{CommonPolicy: loadbalance.CommonLeaderPreferred})
+	rebuild(picker, subConns[1:]...)
+	pick, err := picker.Pick(balancer.PickInfo{
+		FullMethodName: "/geo.v1.Geo/GetServers",
+	})
+	require.NoError(t, err)
+	require.NotEqual(t, subConns[0], pick.SubConn)
+
+	_, err = loadbalance.ParseConfig([]byte(`{"commonPolicy": "nearest"}`))
+	require.ErrorIs(t, err, loadbalance.ErrInvalidPolicy)
+}
+
+func TestPickTrace(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	var traces []loadbalance.PickTrace
+	picker, subConns := setupTest()
+	picker.Logger = zap.New(core)
+	picker.OnPick = func(trace loadbalance.PickTrace) {
+		traces = append(traces, trace)
+	}
+
+	for _, method := range []string{
+		"/geo.v1.Geo/AddAddress",
+		"/geo.v1.Geo/GetServers",
+	} {
+		_, err := picker.Pick(balancer.PickInfo{
+			FullMethodName: method,
+		})
+		require.NoError(t, err)
+	}
+	ctx := loadbalance.WithServer(context.Background(), getAddr(getPort(3)))
+	_, err := picker.Pick(balancer.PickInfo{
+		FullMethodName: "/geo.v1.Geo/GetAddress",
+		Ctx:            ctx,
+	})
+	require.Error(t, err)
+
+	require.Equal(t, []loadbalance.PickTrace{
+		{
+			Method: "/geo.v1.Geo/AddAddress",
+			Route:  loadbalance.RouteLeader,
+			Addr:   subConns[0].addrs[0].Addr,
+			Reason: "leader",
+		},
+		{
+			Method: "/geo.v1.Geo/GetServers",
+			Route:  loadbalance.RouteCommon,
+			Addr:   traces[1].Addr,
+			Reason: "common any",
+		},
+		{
+			Method: "/geo.v1.Geo/GetAddress",
+			Reason: "pinned server",
+			Err:    err,
+		},
+	}, traces)
+	require.NotEmpty(t, traces[1].Addr)
+	require.Equal(t, 3, logs.FilterMessage("pick").Len())
+	require.Equal(t, subConns[0].addrs[0].Addr, logs.FilterMessage("pick").All()[0].ContextMap()["addr"])
+}
+
+func TestPickAttempts(t *testing.T) {
+	picker, subConns := setupConfigTest(&loadbalance.Config{
+		FollowerSelection: loadbalance.SelectRandom,
+	})
+	for i := 0; i < 50; i++ {
+		ctx := loadbalance.WithAttempts(context.Background(), &loadbalance.Attempts{})
+		picks := make(map[balancer.SubConn]int)
+		for j := 0; j < 4; j++ {
+			pick, err := picker.Pick(balancer.PickInfo{
+				FullMethodName: "/geo.v1.Geo/GetAddress",
+				Ctx:            ctx,
+			})
+			require.NoError(t, err)
+			picks[pick.SubConn]++
+		}
+		// attempts go to another follower, until all were tried
+		require.Len(t, picks, 2)
+		require.NotContains(t, picks, subConns[0])
+		require.GreaterOrEqual(t, picks[subConns[1]], 1)
+		require.GreaterOrEqual(t, picks[subConns[2]], 1)
+	}
+}
+
+func TestPickServer(t *testing.T) {
+	picker, subConns := setupTest()
+	for i, sc := range subConns {
+		ctx := loadbalance.WithServer(context.Background(), sc.addrs[0].Addr)
+		for _, method := range []string{"/geo.v1.Geo/GetAddress", "/geo.v1.Geo/AddAddress"} {
+			pick, err := picker.Pick(balancer.PickInfo{
+				FullMethodName: method,
+				Ctx:            ctx,
+			})
+			require.NoError(t, err)
+			require.Equal(t, subConns[i], pick.SubConn)
+		}
+	}
+
+	ctx := loadbalance.WithServer(context.Background(), getAddr(getPort(3)))
+	_, err := picker.Pick(balancer.PickInfo{
+		FullMethodName: "/geo.v1.Geo/GetAddress",
+		Ctx:            ctx,
+	})
+	require.ErrorIs(t, err, loadbalance.ErrServerNotReady)
+}
+
+func TestPickLoadAware(t *testing.T) {
+	for _, selection := range []loadbalance.Selection{
+		loadbalance.SelectLeastLoaded,
+		loadbalance.SelectPowerOfTwo,
+	} {
+		t.Run(string(selection), func(t *testing.T) {
+			picker, subConns := setupConfigTest(&loadbalance.Config{
+				FollowerSelection: selection,
+			})
+			picks := simulateLatency(t, picker, map[balancer.SubConn]int{
+				subConns[1]: 10,
+				subConns[2]: 1,
+			}, 200)
+			// the slow follower gets a small share of the reads
+			require.Less(t, picks[subConns[1]], 40)
+			require.Greater(t, picks[subConns[2]], 160)
+		})
+	}
+
+	// round robin ignores the load
+	picker, subConns := setupTest()
+	picks := simulateLatency(t, picker, map[balancer.SubConn]int{
+		subConns[1]: 10,
+		subConns[2]: 1,
+	}, 200)
+	require.Equal(t, 100, picks[subConns[1]])
+}
+
+func TestPickLoadDone(t *testing.T) {
+	picker, subConns := setupConfigTest(&loadbalance.Config{
+		FollowerSelection: loadbalance.SelectLeastLoaded,
+	})
+	pick, err := picker.Pick(balancer.PickInfo{
+		FullMethodName: "/geo.v1.Geo/GetAddress",
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), picker.Load.Inflight(pick.SubConn))
+	pick.Done(balancer.DoneInfo{})
+	require.Equal(t, int64(0), picker.Load.Inflight(pick.SubConn))
+
+	_, err = picker.Pick(balancer.PickInfo{
+		FullMethodName: "/geo.v1.Geo/AddAddress",
+	})
+	require.NoError(t, err)
+	require.Equal(t, int64(1), picker.Load.Inflight(subConns[0]))
+}
+
+func TestPickLatency(t *testing.T) {
+	probe := 0.05
+	picker, subConns := setupConfigTest(&loadbalance.Config{
+		FollowerSelection: loadbalance.SelectLatency,
+		LatencyProbe:      &probe,
+	})
+	picks := simulateLatency(t, picker, map[balancer.SubConn]int{
+		subConns[1]: 20,
+		subConns[2]: 2,
+	}, 1000)
+	// the slow follower still gets probed
+	require.Greater(t, picks[subConns[1]], 0)
+	require.Less(t, picks[subConns[1]], 150)
+
+	slow, ok := picker.Load.Latency(subConns[1])
+	require.True(t, ok)
+	fast, ok := picker.Load.Latency(subConns[2])
+	require.True(t, ok)
+	require.Equal(t, 20*time.Millisecond, slow)
+	require.Equal(t, 2*time.Millisecond, fast)
+}
+
+func TestPickLatencyUnsampled(t *testing.T) {
+	probe := 0.0
+	picker, subConns := setupConfigTest(&loadbalance.Config{
+		FollowerSelection: loadbalance.SelectLatency,
+		LatencyProbe:      &probe,
+	})
+	var now time.Time
+	loadbalance.SetClock(picker.Load, func() time.Time {
+		return now
+	})
+
+	// one follower is sampled before the other joins
+	rebuild(picker, subConns[0], subConns[2])
+	pick, err := picker.Pick(balancer.PickInfo{
+		FullMethodName: "/geo.v1.Geo/GetAddress",
+	})
+	require.NoError(t, err)
+	now = now.Add(2 * time.Millisecond)
+	pick.Done(balancer.DoneInfo{})
+	rebuild(picker, subConns...)
+
+	// the new follower isn't sent all the reads until it answers one
+	picks := make(map[balancer.SubConn]int)
+	for i := 0; i < 100; i++ {
+		pick, err := picker.Pick(balancer.PickInfo{
+			FullMethodName: "/geo.v1.Geo/GetAddress",
+		})
+		require.NoError(t, err)
+		picks[pick.SubConn]++
+	}
+	require.InDelta(t, 50, picks[subConns[1]], 1)
+	require.InDelta(t, 50, picks[subConns[2]], 1)
+}
+
+func TestLatencyProbeConfig(t *testing.T) {
+	_, err := loadbalance.ParseConfig([]byte(`{"followerSelection": "latency", "latencyProbe": 1.5}`))
+	require.ErrorIs(t, err, loadbalance.ErrInvalidProbe)
+
+	cfg, err := loadbalance.ParseConfig([]byte(`{"followerSelection": "latency", "latencyProbe": 0}`))
+	require.NoError(t, err)
+	require.Equal(t, 0.0, *cfg.LatencyProbe)
+}
+
+func TestPickLocality(t *testing.T) {
+	cfg := &loadbalance.Config{
+		Locality: loadbalance.Locality{Zone: "us-east-1a", Region: "us-east-1"},
+	}
+	servers := []loadbalance.Server{
+		{Addr: "localhost:9001", IsLeader: true, Zone: "us-east-1a", Region: "us-east-1"},
+		{Addr: "localhost:9002", Zone: "us-east-1a", Region: "us-east-1"},
+		{Addr: "localhost:9003", Zone: "us-east-1b", Region: "us-east-1"},
+		{Addr: "localhost:9004", Zone: "us-west-2a", Region: "us-west-2"},
+	}
+	readers := func(servers ...loadbalance.Server) map[string]int {
+		picker := &loadbalance.Picker{Config: cfg}
+		buildInfo := base.PickerBuildInfo{
+			ReadySCs: make(map[balancer.SubConn]base.SubConnInfo),
+		}
+		for _, server := range servers {
+			addr := resolver.Address{
+				Addr:       server.Addr,
+				Attributes: attributes.New("is_leader", server.IsLeader),
+			}
+			if server.Zone != "" {
+				addr.Attributes = addr.Attributes.WithValue("zone", server.Zone)
+			}
+			if server.Region != "" {
+				addr.Attributes = addr.Attributes.WithValue("region", server.Region)
+			}
+			sc := &subConn{}
+			sc.UpdateAddresses([]resolver.Address{addr})
+			buildInfo.ReadySCs[sc] = base.SubConnInfo{Address: addr}
+		}
+		picker.Build(buildInfo)
+
+		picks := make(map[string]int)
+		for i := 0; i < 10; i++ {
+			pick, err := picker.Pick(balancer.PickInfo{
+				FullMethodName: "/geo.v1.Geo/GetAddress",
+			})
+			require.NoError(t, err)
+			picks[pick.SubConn.(*subConn).addrs[0].Addr]++
+		}
+		return picks
+	}
+
+	// same zone
+	require.Equal(t, map[string]int{"localhost:9002": 10}, readers(servers...))
+	// same region
+	require.Equal(t, map[string]int{"localhost:9003": 10}, readers(servers[0], servers[2], servers[3]))
+	// any zone
+	require.Equal(t, map[string]int{"localhost:9004": 10}, readers(servers[0], servers[3]))
+}
+
+func TestPickOutlierEjection(t *testing.T) {
+	picker, subConns := setupConfigTest(&loadbalance.Config{
+		OutlierEjection: &loadbalance.OutlierEjection{
+			MinRequests:      5,
+			BaseEjectionTime: loadbalance.Duration(10 * time.Second),
+			RecoveryTime:     loadbalance.Duration(10 * time.Second),
+		},
+	})
+	now := time.Unix(0, 0)
+	loadbalance.SetClock(picker.Load, func() time.Time {
+		return now
+	})
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	read := func(failing balancer.SubConn) balancer.SubConn {
+		pick, err := picker.Pick(balancer.PickInfo{
+			FullMethodName: "/geo.v1.Geo/GetAddress",
+		})
+		require.NoError(t, err)
+		var doneErr error
+		if pick.SubConn == failing {
+			doneErr = unavailable
+		}
+		pick.Done(balancer.DoneInfo{Err: doneErr})
+		return pick.SubConn
+	}
+	reads := func(n int, failing balancer.SubConn) map[balancer.SubConn]int {
+		picks := make(map[balancer.SubConn]int)
+		for i := 0; i < n; i++ {
+			picks[read(failing)]++
+		}
+		return picks
+	}
+
+	// failing follower is ejected
+	reads(20, subConns[1])
+	require.True(t, picker.Load.Ejected(subConns[1]))
+	require.False(t, picker.Load.Ejected(subConns[2]))
+	require.Equal(t, map[balancer.SubConn]int{subConns[2]: 100}, reads(100, nil))
+
+	// and stays ejected when reconnecting
+	rebuild(picker, subConns[0], subConns[2])
+	rebuild(picker, subConns...)
+	require.True(t, picker.Load.Ejected(subConns[1]))
+	require.Equal(t, map[balancer.SubConn]int{subConns[2]: 100}, reads(100, nil))
+
+	// and gets its reads back gradually
+	now = now.Add(11 * time.Second)
+	require.False(t, picker.Load.Ejected(subConns[1]))
+	picks := reads(1000, nil)
+	require.Greater(t, picks[subConns[1]], 0)
+	require.Less(t, picks[subConns[1]], 400)
+	now = now.Add(10 * time.Second)
+	require.Equal(t, 500, reads(1000, nil)[subConns[1]])
+
+	// ejections in a row back off
+	reads(20, subConns[1])
+	require.True(t, picker.Load.Ejected(subConns[1]))
+	now = now.Add(11 * time.Second)
+	reads(500, subConns[1])
+	require.True(t, picker.Load.Ejected(subConns[1]))
+	now = now.Add(11 * time.Second)
+	require.True(t, picker.Load.Ejected(subConns[1]))
+	now = now.Add(10 * time.Second)
+	require.False(t, picker.Load.Ejected(subConns[1]))
+}
+
+func TestPickOutlierFallback(t *testing.T) {
+	picker, subConns := setupConfigTest(&loadbalance.Config{
+		OutlierEjection: &loadbalance.OutlierEjection{MinRequests: 5},
+	})
+	var trace loadbalance.PickTrace
+	picker.OnPick = func(t loadbalance.PickTrace) {
+		trace = t
+	}
+	deadline := status.Error(codes.DeadlineExceeded, "deadline exceeded")
+	for i := 0; i < 40; i++ {
+		pick, err := picker.Pick(balancer.PickInfo{
+			FullMethodName: "/geo.v1.Geo/GetAddress",
+		})
+		require.NoError(t, err)
+		pick.Done(balancer.DoneInfo{Err: deadline})
+	}
+	require.True(t, picker.Load.Ejected(subConns[1]))
+	require.True(t, picker.Load.Ejected(subConns[2]))
+
+	// reads fall back to the leader
+	pick, err := picker.Pick(balancer.PickInfo{
+		FullMethodName: "/geo.v1.Geo/GetAddress",
+	})
+	require.NoError(t, err)
+	require.Equal(t, subConns[0], pick.SubConn)
+	require.Equal(t, "followers ejected, leader", trace.Reason)
+
+	// without leader, the ejected followers are kept
+	rebuild(picker, subConns[1:]...)
+	pick, err = picker.Pick(balancer.PickInfo{
+		FullMethodName: "/geo.v1.Geo/GetAddress",
+	})
+	require.NoError(t, err)
+	require.NotEqual(t, subConns[0], pick.SubConn)
+}
+
+func TestOutlierEjectionConfig(t *testing.T) {
+	cfg, err := loadbalance.ParseConfig([]byte(`{"outlierEjection": {"errorRate": 0.3, "baseEjectionTime": "10s"}}`))
+	require.NoError(t, err)
+	require.Equal(t, loadbalance.Duration(10*time.Second), cfg.OutlierEjection.BaseEjectionTime)
+
+	_, err = loadbalance.ParseConfig([]byte(`{"outlierEjection": {"errorRate": 2}}`))
+	require.ErrorIs(t, err, loadbalance.ErrInvalidEjection)
+	_, err = loadbalance.ParseConfig([]byte(`{"outlierEjection": {"baseEjectionTime": "soon"}}`))
+	require.Error(t, err)
+}
+
+// simulateLatency picks reads one per millisecond tick, each finishing
+// after its SubConn latency in ticks, and returns the picks per SubConn.
+func simulateLatency(
+	t *testing.T,
+	picker *loadbalance.Picker,
+	latency map[balancer.SubConn]int,
+	ticks int,
+) map[balancer.SubConn]int {
+	t.Helper()
+
+	var now time.Time
+	loadbalance.SetClock(picker.Load, func() time.Time {
+		return now
+	})
+
+	picks := make(map[balancer.SubConn]int)
+	pending := make(map[int][]func(balancer.DoneInfo))
+	for tick := 0; tick < ticks; tick++ {
+		now = time.Unix(0, 0).Add(time.Duration(tick) * time.Millisecond)
+		for _, done := range pending[tick] {
+			done(balancer.DoneInfo{})
+		}
+		delete(pending, tick)
+
+		pick, err := picker.Pick(balancer.PickInfo{
+			FullMethodName: "/geo.v1.Geo/GetAddress",
+		})
+		require.NoError(t, err)
+		picks[pick.SubConn]++
+		end := tick + latency[pick.SubConn]
+		pending[end] = append(pending[end], pick.Done)
+	}
+	return picks
+}
+
 // subConn implements balancer.SubConn.
 type subConn struct {
 	balancer.SubConn
@@ -70,6 +678,10 @@ func (s *subConn) UpdateAddresses(addrs []resolver.Address) {
 func (s *subConn) Connect() {}
 
 func setupTest() (*loadbalance.Picker, []*subConn) {
+	return setupConfigTest(nil)
+}
+
+func setupConfigTest(cfg *loadbalance.Config) (*loadbalance.Picker, []*subConn) {
 	var subConns []*subConn
 	buildInfo := base.PickerBuildInfo{
 		ReadySCs: make(map[balancer.SubConn]base.SubConnInfo),
@@ -88,11 +700,22 @@ func setupTest() (*loadbalance.Picker, []*subConn) {
 		buildInfo.ReadySCs[sc] = base.SubConnInfo{Address: addr}
 		subConns = append(subConns, sc)
 	}
-	picker := &loadbalance.Picker{}
+	picker := &loadbalance.Picker{Config: cfg}
 	picker.Build(buildInfo)
 	return picker, subConns
 }
 
+// rebuild builds the picker with the ready sub conns.
+func rebuild(picker *loadbalance.Picker, ready ...*subConn) {
+	buildInfo := base.PickerBuildInfo{
+		ReadySCs: make(map[balancer.SubConn]base.SubConnInfo),
+	}
+	for _, sc := range ready {
+		buildInfo.ReadySCs[sc] = base.SubConnInfo{Address: sc.addrs[0]}
+	}
+	picker.Build(buildInfo)
+}
+
 func getPort(id int) int {
 	return 61059 + (id * 2)
 }
@@ -2,17 +2,23 @@ package loadbalance
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/backoff"
 	"google.golang.org/grpc/resolver"
 	"google.golang.org/grpc/serviceconfig"
 
 	config "github.com/comfforts/comff-config"
-	api "github.com/comfforts/comff-geo/api/v1"
 )
 
 var _ resolver.Builder = (*Resolver)(nil)
@@ -20,12 +26,62 @@ var _ resolver.Resolver = (*Resolver)(nil)
 
 const GeoCQRSResolverName = config.GeoCQRSResolverName
 
+const defaultResolveTimeout = 5 * time.Second
+
+// Resolver resolves the geo cluster members from its discovery source,
+// by default the GetServers RPC on the comma separated target host seeds.
+// The registered or configured Resolver only acts as the builder, every
+// Build returns a new Resolver owning its client conn state.
 type Resolver struct {
-	mu            sync.Mutex
+	// Discovery builds the discovery source, NewServersDiscovery when not set.
+	Discovery DiscoveryBuilder
+	// BalancerConfig is the geo-cqrs balancer config JSON, see Config.
+	BalancerConfig string
+	// DialOptions are appended to the resolver connection dial options.
+	DialOptions []grpc.DialOption
+	// RefreshInterval is the period of the background server list
+	// refresh, zero disables it.
+	RefreshInterval time.Duration
+	// RefreshJitter adds up to this random delay to each refresh.
+	RefreshJitter time.Duration
+	// ResolveTimeout bounds each discovery.
+	ResolveTimeout time.Duration
+	// Backoff configures the retry delays after a failed resolution,
+	// gRPC's ResolveNow requests wait for them too.
+	Backoff backoff.Config
+	// OnEvent, when set, is called with the membership changes of each
+	// resolution. It must not block.
+	OnEvent func(Event)
+	// CacheFile, when set, persists the last good server list, used when
+	// the seed is unavailable on start.
+	CacheFile string
+	// Localities assigns the zone and region of the servers, keyed on
+	// their address, when their discovery doesn't provide them.
+	Localities map[string]Locality
+	// HealthCheck enables the grpc.health.v1 checks of the servers for
+	// HealthCheckService, the overall server health when empty. Unhealthy
+	// servers aren't picked.
+	HealthCheck        bool
+	HealthCheckService string
+	// Logger logs the resolver and picker diagnostics, the global zap
+	// logger when not set.
+	Logger *zap.Logger
+	// OnPick, when set, is called with every pick decision of the
+	// client connection. It must not block.
+	OnPick func(PickTrace)
+
 	clientConn    resolver.ClientConn
-	resolverConn  *grpc.ClientConn
+	discovery     Discovery
 	serviceConfig *serviceconfig.ParseResult
 	logger        *zap.Logger
+	diagnostics   *Diagnostics
+	addrs         []resolver.Address
+	resolved      bool
+	failures      int
+	resolveNow    chan struct{}
+	ctx           context.Context
+	cancel        context.CancelFunc
+	wg            sync.WaitGroup
 }
 
 func init() {
@@ -37,54 +93,233 @@ func (r *Resolver) Scheme() string {
 }
 
 func (r *Resolver) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
-	r.logger = zap.L().Named(fmt.Sprintf("%s-resolver", r.Scheme()))
-	r.clientConn = cc
+	res := &Resolver{
+		Discovery:          r.Discovery,
+		BalancerConfig:     r.BalancerConfig,
+		DialOptions:        r.DialOptions,
+		RefreshInterval:    r.RefreshInterval,
+		RefreshJitter:      r.RefreshJitter,
+		ResolveTimeout:     r.ResolveTimeout,
+		Backoff:            r.Backoff,
+		CacheFile:          r.CacheFile,
+		OnEvent:            r.OnEvent,
+		Localities:         r.Localities,
+		HealthCheck:        r.HealthCheck,
+		HealthCheckService: r.HealthCheckService,
+		Logger:             r.Logger,
+		OnPick:             r.OnPick,
+		clientConn:         cc,
+		resolveNow:         make(chan struct{}, 1),
+	}
+	logger := res.Logger
+	if logger == nil {
+		logger = zap.L()
+	}
+	res.logger = logger.Named(fmt.Sprintf("%s-resolver", r.Scheme()))
+	if res.Logger != nil || res.OnPick != nil {
+		res.diagnostics = &Diagnostics{Logger: res.Logger, OnPick: res.OnPick}
+	}
+	if res.ResolveTimeout == 0 {
+		res.ResolveTimeout = defaultResolveTimeout
+	}
+	if res.Backoff.BaseDelay == 0 {
+		res.Backoff = backoff.DefaultConfig
+	}
+
+	// resolver connection uses the same credentials as the client connection
 	var dialOpts []grpc.DialOption
 	if opts.DialCreds != nil {
 		dialOpts = append(
 			dialOpts,
 			grpc.WithTransportCredentials(opts.DialCreds),
 		)
+	} else if opts.CredsBundle != nil {
+		dialOpts = append(
+			dialOpts,
+			grpc.WithCredentialsBundle(opts.CredsBundle),
+		)
+	}
+	if opts.Dialer != nil {
+		dialOpts = append(
+			dialOpts,
+			grpc.WithContextDialer(opts.Dialer),
+		)
+	}
+	dialOpts = append(dialOpts, res.DialOptions...)
+	balancerConfig := res.BalancerConfig
+	if balancerConfig == "" {
+		balancerConfig = "{}"
+	}
+	res.serviceConfig = res.clientConn.ParseServiceConfig(res.serviceConfigJSON(balancerConfig))
+	if res.serviceConfig.Err != nil {
+		return nil, res.serviceConfig.Err
+	}
+
+	// target host is a comma separated seed list
+	newDiscovery := res.Discovery
+	if newDiscovery == nil {
+		newDiscovery = NewServersDiscovery
 	}
-	r.serviceConfig = r.clientConn.ParseServiceConfig(
-		fmt.Sprintf(`{"loadBalancingConfig":[{"%s":{}}]}`, GeoCQRSResolverName),
-	)
 	var err error
-	r.resolverConn, err = grpc.Dial(target.URL.Host, dialOpts...)
+	res.discovery, err = newDiscovery(ParseSeeds(target.URL.Host), dialOpts)
 	if err != nil {
 		return nil, err
 	}
-	r.ResolveNow(resolver.ResolveNowOptions{})
-	return r, nil
+
+	// the first resolution doesn't block the dial
+	res.ctx, res.cancel = context.WithCancel(context.Background())
+	res.wg.Add(1)
+	go res.refresh()
+	return res, nil
+}
+
+// serviceConfigJSON returns the service config with the balancer
+// config, and the health check config when enabled.
+func (r *Resolver) serviceConfigJSON(balancerConfig string) string {
+	healthCheckConfig := ""
+	if r.HealthCheck {
+		serviceName, _ := json.Marshal(r.HealthCheckService)
+		healthCheckConfig = fmt.Sprintf(`,"healthCheckConfig":{"serviceName":%s}`, serviceName)
+	}
+	return fmt.Sprintf(
+		`{"loadBalancingConfig":[{"%s":%s}]%s}`,
+		GeoCQRSResolverName,
+		balancerConfig,
+		healthCheckConfig,
+	)
 }
 
-func (r *Resolver) ResolveNow(resolver.ResolveNowOptions) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// refresh resolves the server list on start, periodically, on discovery
+// changes and ResolveNow requests, and with backoff after failures, until
+// the resolver is closed. The resolver state is only used here.
+func (r *Resolver) refresh() {
+	defer r.wg.Done()
 
-	client := api.NewGeoClient(r.resolverConn)
+	var changes <-chan struct{}
+	if w, ok := r.discovery.(Watcher); ok {
+		changes = w.Changes()
+	}
+	r.resolve()
+	for {
+		var timer *time.Timer
+		var timerC <-chan time.Time
+		if delay, ok := r.nextDelay(); ok {
+			timer = time.NewTimer(delay)
+			timerC = timer.C
+		}
+		ok := r.wait(timerC, changes)
+		stopTimer(timer)
+		if !ok {
+			return
+		}
+		r.resolve()
+	}
+}
+
+// wait waits for the next resolution, returning false when the resolver
+// is closed. ResolveNow requests wait for the backoff after failures.
+func (r *Resolver) wait(timer <-chan time.Time, changes <-chan struct{}) bool {
+	for {
+		select {
+		case <-r.ctx.Done():
+			return false
+		case <-r.resolveNow:
+			if r.failures == 0 {
+				return true
+			}
+		case <-changes:
+			return true
+		case <-timer:
+			return true
+		}
+	}
+}
 
-	// get server list
-	ctx := context.Background()
-	res, err := client.GetServers(ctx, &api.GetServersRequest{})
+// nextDelay returns the delay to the next resolution, backing off
+// after failures. ok is false when there's nothing scheduled.
+func (r *Resolver) nextDelay() (delay time.Duration, ok bool) {
+	if r.failures > 0 {
+		return BackoffDelay(r.Backoff, r.failures-1), true
+	}
+	if r.RefreshInterval <= 0 {
+		return 0, false
+	}
+	delay = r.RefreshInterval
+	if r.RefreshJitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(r.RefreshJitter)))
+	}
+	return delay, true
+}
+
+// ResolveNow asks the refresh goroutine to resolve, without blocking
+// the gRPC caller.
+func (r *Resolver) ResolveNow(resolver.ResolveNowOptions) {
+	select {
+	case r.resolveNow <- struct{}{}:
+	default:
+	}
+}
+
+// resolve discovers the server list, and updates the client conn state
+// on changes.
+func (r *Resolver) resolve() {
+	ctx, cancel := context.WithTimeout(r.ctx, r.ResolveTimeout)
+	defer cancel()
+	addrs, err := r.discovery.Discover(ctx)
+	if err == nil && len(addrs) == 0 {
+		// an empty state would close all the connections
+		err = ErrNoServers
+	}
 	if err != nil {
-		r.logger.Error("failed to get server list", zap.Error(err))
+		if r.ctx.Err() != nil {
+			// closed
+			return
+		}
+		r.failures++
+		r.logger.Error("failed to get server list", zap.Error(err), zap.Int("failures", r.failures))
+
+		// keep serving the last good server list
+		if !r.resolved {
+			if addrs, cErr := readCache(r.CacheFile); cErr != nil {
+				r.logger.Error("failed to read server list cache", zap.Error(cErr))
+			} else if len(addrs) > 0 {
+				r.logger.Info("using cached server list", zap.Int("servers", len(addrs)))
+				r.updateAddresses(addrs)
+			}
+		}
+		r.clientConn.ReportError(err)
 		return
 	}
-	var addrs []resolver.Address
-	for _, server := range res.Servers {
-		addrs = append(addrs, resolver.Address{
-			Addr: server.Addr,
-			Attributes: attributes.New(
-				"is_leader",
-				server.IsLeader,
-			),
-		})
+	r.failures = 0
+	addrs = assignLocalities(addrs, r.Localities)
+
+	// only update state when the server set or leader changed
+	if r.resolved && sameAddresses(r.addrs, addrs) {
+		return
+	}
+	r.updateAddresses(addrs)
+	if err := writeCache(r.CacheFile, addrs); err != nil {
+		r.logger.Error("failed to write server list cache", zap.Error(err))
 	}
-	r.UpdateState(resolver.State{
+}
+
+// updateAddresses updates the client conn state and notifies the
+// membership changes.
+func (r *Resolver) updateAddresses(addrs []resolver.Address) {
+	addrs = assignServerNames(addrs)
+	events := membershipEvents(r.addrs, addrs)
+	r.addrs = addrs
+	r.resolved = true
+	r.logger.Debug("resolved servers", zap.Int("servers", len(addrs)), zap.Int("events", len(events)))
+	r.UpdateState(withDiagnostics(resolver.State{
 		Addresses:     addrs,
 		ServiceConfig: r.serviceConfig,
-	})
+	}, r.diagnostics))
+	if r.OnEvent != nil {
+		for _, event := range events {
+			r.OnEvent(event)
+		}
+	}
 }
 
 func (r *Resolver) UpdateState(s resolver.State) {
@@ -94,7 +329,119 @@ func (r *Resolver) UpdateState(s resolver.State) {
 }
 
 func (r *Resolver) Close() {
-	if err := r.resolverConn.Close(); err != nil {
-		r.logger.Error("failed to close conn", zap.Error(err))
+	r.cancel()
+	r.wg.Wait()
+
+	if err := r.discovery.Close(); err != nil {
+		r.logger.Error("failed to close discovery", zap.Error(err))
+	}
+}
+
+// ParseSeeds splits a comma separated seed list.
+func ParseSeeds(hosts string) []string {
+	var seeds []string
+	for _, seed := range strings.Split(hosts, ",") {
+		if seed = strings.TrimSpace(seed); seed != "" {
+			seeds = append(seeds, seed)
+		}
+	}
+	return seeds
+}
+
+// sameAddresses reports whether both lists have the same servers,
+// leader and localities.
+func sameAddresses(a, b []resolver.Address) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	servers := make(map[string]resolver.Address, len(a))
+	for _, addr := range a {
+		servers[addr.Addr] = addr
+	}
+	for _, addr := range b {
+		server, ok := servers[addr.Addr]
+		if !ok || isLeader(server) != isLeader(addr) || localityOf(server) != localityOf(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// assignServerNames sets the host of the addresses without a server name
+// as their name, verified against each server's TLS certificate unless
+// the transport credentials override it.
+func assignServerNames(addrs []resolver.Address) []resolver.Address {
+	assigned := make([]resolver.Address, 0, len(addrs))
+	for _, addr := range addrs {
+		if addr.ServerName == "" {
+			addr.ServerName = addr.Addr
+			if host, _, err := net.SplitHostPort(addr.Addr); err == nil {
+				addr.ServerName = host
+			}
+		}
+		assigned = append(assigned, addr)
+	}
+	return assigned
+}
+
+func isLeader(addr resolver.Address) bool {
+	leader, _ := addr.Attributes.Value("is_leader").(bool)
+	return leader
+}
+
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// BackoffDelay returns the exponential backoff delay, with jitter, for
+// the given retry count.
+func BackoffDelay(cfg backoff.Config, retries int) time.Duration {
+	delay, max := float64(cfg.BaseDelay), float64(cfg.MaxDelay)
+	for delay < max && retries > 0 {
+		delay *= cfg.Multiplier
+		retries--
+	}
+	if delay > max {
+		delay = max
+	}
+	delay *= 1 + cfg.Jitter*(rand.Float64()*2-1)
+	if delay < 0 {
+		return 0
+	}
+	return time.Duration(delay)
+}
+
+// readCache reads the server list saved by writeCache, if any.
+func readCache(file string) ([]resolver.Address, error) {
+	if file == "" {
+		return nil, nil
+	}
+	servers, err := readServers(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return addresses(servers), nil
+}
+
+// writeCache saves the server list, in the file discovery format.
+func writeCache(file string, addrs []resolver.Address) error {
+	if file == "" {
+		return nil
+	}
+	servers := make([]Server, 0, len(addrs))
+	for _, addr := range addrs {
+		l := localityOf(addr)
+		servers = append(servers, Server{
+			Addr:     addr.Addr,
+			IsLeader: isLeader(addr),
+			Zone:     l.Zone,
+			Region:   l.Region,
+		})
 	}
+	return writeServers(file, servers)
 }
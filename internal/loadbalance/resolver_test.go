@@ -0,0 +1,468 @@
+package loadbalance_test
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+
+	api "github.com/comfforts/comff-geo/api/v1"
+
+	"github.com/comfforts/comff-geo-client/internal/loadbalance"
+)
+
+func TestResolverRefresh(t *testing.T) {
+	srv, addr, teardown := setupTestServer(t)
+	defer teardown()
+
+	srv.setServers(
+		&api.Server{Id: "leader", Addr: "localhost:9001", IsLeader: true},
+		&api.Server{Id: "follower-1", Addr: "localhost:9002"},
+	)
+
+	cc := &clientConn{}
+	teardownResolver := buildResolver(t, &loadbalance.Resolver{
+		RefreshInterval: 20 * time.Millisecond,
+	}, addr, cc)
+	defer teardownResolver()
+
+	require.Eventually(t, func() bool {
+		return cc.updates() == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Len(t, cc.state().Addresses, 2)
+	// servers are verified against their own host
+	require.Equal(t, "localhost", cc.state().Addresses[1].ServerName)
+
+	// unchanged server list doesn't update state
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, 1, cc.updates())
+
+	// new follower is picked up by the refresh
+	srv.setServers(
+		&api.Server{Id: "leader", Addr: "localhost:9001", IsLeader: true},
+		&api.Server{Id: "follower-1", Addr: "localhost:9002"},
+		&api.Server{Id: "follower-2", Addr: "localhost:9003"},
+	)
+	require.Eventually(t, func() bool {
+		return cc.updates() == 2
+	}, time.Second, 10*time.Millisecond)
+	require.Len(t, cc.state().Addresses, 3)
+
+	// leader move is picked up by the refresh
+	srv.setServers(
+		&api.Server{Id: "leader", Addr: "localhost:9001"},
+		&api.Server{Id: "follower-1", Addr: "localhost:9002", IsLeader: true},
+		&api.Server{Id: "follower-2", Addr: "localhost:9003"},
+	)
+	require.Eventually(t, func() bool {
+		return cc.updates() == 3
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestResolverSeedUnavailable(t *testing.T) {
+	srv, addr, teardown := setupTestServer(t)
+	defer teardown()
+
+	srv.setServers(
+		&api.Server{Id: "leader", Addr: "localhost:9001", IsLeader: true},
+		&api.Server{Id: "follower-1", Addr: "localhost:9002"},
+	)
+
+	cacheFile := filepath.Join(t.TempDir(), "servers.json")
+	cc := &clientConn{}
+	teardownResolver := buildResolver(t, &loadbalance.Resolver{
+		CacheFile: cacheFile,
+	}, addr, cc)
+	require.Eventually(t, func() bool {
+		return cc.updates() == 1
+	}, time.Second, 10*time.Millisecond)
+	teardownResolver()
+	require.FileExists(t, cacheFile)
+
+	// hung seed is bounded by the resolve timeout and reported
+	srv.block()
+	defer srv.unblock()
+
+	cc = &clientConn{}
+	start := time.Now()
+	teardownResolver = buildResolver(t, &loadbalance.Resolver{
+		ResolveTimeout: 50 * time.Millisecond,
+		Backoff: backoff.Config{
+			BaseDelay:  10 * time.Millisecond,
+			Multiplier: 2,
+			MaxDelay:   50 * time.Millisecond,
+		},
+		CacheFile: cacheFile,
+	}, addr, cc)
+	defer teardownResolver()
+	require.Less(t, time.Since(start), time.Second)
+
+	// last good server list is used
+	require.Eventually(t, func() bool {
+		return cc.updates() == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Len(t, cc.state().Addresses, 2)
+
+	// retries with backoff, reporting each error
+	require.Eventually(t, func() bool {
+		return cc.errors() >= 3
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, 1, cc.updates())
+}
+
+func TestResolverEmptySeed(t *testing.T) {
+	_, emptyAddr, teardown := setupTestServer(t)
+	defer teardown()
+	srv, addr, teardown := setupTestServer(t)
+	defer teardown()
+
+	srv.setServers(
+		&api.Server{Id: "leader", Addr: "localhost:9001", IsLeader: true},
+		&api.Server{Id: "follower-1", Addr: "localhost:9002"},
+	)
+	// the seed without members answers first
+	srv.block()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		srv.unblock()
+	}()
+
+	cacheFile := filepath.Join(t.TempDir(), "servers.json")
+	cc := &clientConn{}
+	teardownResolver := buildResolver(t, &loadbalance.Resolver{
+		RefreshInterval: 20 * time.Millisecond,
+		Backoff: backoff.Config{
+			BaseDelay:  10 * time.Millisecond,
+			Multiplier: 2,
+			MaxDelay:   50 * time.Millisecond,
+		},
+		CacheFile: cacheFile,
+	}, emptyAddr+","+addr, cc)
+	defer teardownResolver()
+
+	require.Eventually(t, func() bool {
+		return cc.updates() == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Len(t, cc.state().Addresses, 2)
+
+	// all seeds answering empty is an error, the last good server list
+	// is kept, also in the cache
+	srv.setServers()
+	require.Eventually(t, func() bool {
+		return cc.errors() >= 2
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, 1, cc.updates())
+
+	d, err := loadbalance.FileDiscovery(cacheFile, time.Minute)(nil, nil)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, d.Close())
+	}()
+	addrs, err := d.Discover(context.Background())
+	require.NoError(t, err)
+	require.Len(t, addrs, 2)
+}
+
+func TestResolverResolveNow(t *testing.T) {
+	discovery := &blockingDiscovery{unblock: make(chan struct{})}
+	builder := &loadbalance.Resolver{
+		Discovery: func([]string, []grpc.DialOption) (loadbalance.Discovery, error) {
+			return discovery, nil
+		},
+		ResolveTimeout: time.Minute,
+		Backoff: backoff.Config{
+			BaseDelay:  200 * time.Millisecond,
+			Multiplier: 1,
+			MaxDelay:   200 * time.Millisecond,
+		},
+	}
+	cc := &clientConn{}
+	target := resolver.Target{}
+	target.URL.Scheme = loadbalance.GeoCQRSResolverName
+	start := time.Now()
+	r, err := builder.Build(target, cc, resolver.BuildOptions{})
+	require.NoError(t, err)
+	defer r.Close()
+
+	// hung discovery blocks neither the build nor gRPC's resolve requests
+	for i := 0; i < 10; i++ {
+		r.ResolveNow(resolver.ResolveNowOptions{})
+	}
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return discovery.calls() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// resolve requests wait for the backoff after the failure
+	close(discovery.unblock)
+	require.Eventually(t, func() bool {
+		return cc.errors() == 1
+	}, time.Second, 10*time.Millisecond)
+	failed := time.Now()
+	for time.Since(failed) < 100*time.Millisecond {
+		r.ResolveNow(resolver.ResolveNowOptions{})
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, 1, discovery.calls())
+	require.Eventually(t, func() bool {
+		return discovery.calls() >= 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestResolverLocalities(t *testing.T) {
+	cacheFile := filepath.Join(t.TempDir(), "servers.json")
+	cc := &clientConn{}
+	teardown := buildResolver(t, &loadbalance.Resolver{
+		Discovery: loadbalance.StaticDiscovery(
+			loadbalance.Server{Addr: "localhost:9001", IsLeader: true, Zone: "us-east-1a", Region: "us-east-1"},
+			loadbalance.Server{Addr: "localhost:9002"},
+			loadbalance.Server{Addr: "localhost:9003"},
+		),
+		Localities: map[string]loadbalance.Locality{
+			"localhost:9001": {Zone: "us-east-1c"},
+			"localhost:9002": {Zone: "us-east-1b", Region: "us-east-1"},
+		},
+		CacheFile: cacheFile,
+	}, "", cc)
+	defer teardown()
+
+	zones := func(addrs []resolver.Address) []interface{} {
+		var zones []interface{}
+		for _, addr := range addrs {
+			zones = append(zones, addr.Attributes.Value("zone"))
+		}
+		return zones
+	}
+	// discovered locality wins over the mapping
+	require.Eventually(t, func() bool {
+		return cc.updates() == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []interface{}{"us-east-1a", "us-east-1b", nil}, zones(cc.state().Addresses))
+
+	// cache keeps the localities
+	cc = &clientConn{}
+	teardown = buildResolver(t, &loadbalance.Resolver{
+		Discovery: func([]string, []grpc.DialOption) (loadbalance.Discovery, error) {
+			return failingDiscovery{}, nil
+		},
+		CacheFile: cacheFile,
+	}, "", cc)
+	defer teardown()
+	require.Eventually(t, func() bool {
+		return cc.updates() == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []interface{}{"us-east-1a", "us-east-1b", nil}, zones(cc.state().Addresses))
+}
+
+func TestResolverEvents(t *testing.T) {
+	srv, addr, teardown := setupTestServer(t)
+	defer teardown()
+
+	srv.setServers(
+		&api.Server{Id: "leader", Addr: "localhost:9001", IsLeader: true},
+		&api.Server{Id: "follower-1", Addr: "localhost:9002"},
+	)
+
+	var mu sync.Mutex
+	var events []loadbalance.Event
+	received := func() []loadbalance.Event {
+		mu.Lock()
+		defer mu.Unlock()
+		return events
+	}
+
+	cc := &clientConn{}
+	teardownResolver := buildResolver(t, &loadbalance.Resolver{
+		RefreshInterval: 10 * time.Millisecond,
+		OnEvent: func(e loadbalance.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, e)
+		},
+	}, addr, cc)
+	defer teardownResolver()
+
+	require.Eventually(t, func() bool {
+		return len(received()) == 2
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []loadbalance.Event{
+		{Type: loadbalance.LeaderElected, Addr: "localhost:9001"},
+		{Type: loadbalance.FollowerAdded, Addr: "localhost:9002"},
+	}, received())
+
+	// follower takes over as leader, old leader leaves
+	srv.setServers(
+		&api.Server{Id: "follower-1", Addr: "localhost:9002", IsLeader: true},
+		&api.Server{Id: "follower-2", Addr: "localhost:9003"},
+	)
+	require.Eventually(t, func() bool {
+		return len(received()) == 6
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, []loadbalance.Event{
+		{Type: loadbalance.LeaderLost, Addr: "localhost:9001"},
+		{Type: loadbalance.FollowerRemoved, Addr: "localhost:9002"},
+		{Type: loadbalance.LeaderElected, Addr: "localhost:9002"},
+		{Type: loadbalance.FollowerAdded, Addr: "localhost:9003"},
+	}, received()[2:])
+}
+
+// getServersServer serves a mutable server list.
+type getServersServer struct {
+	api.UnimplementedGeoServer
+	mu      sync.Mutex
+	servers []*api.Server
+	blocked chan struct{}
+}
+
+// block makes GetServers hang until unblock.
+func (s *getServersServer) block() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.blocked = make(chan struct{})
+}
+
+func (s *getServersServer) unblock() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	close(s.blocked)
+}
+
+func (s *getServersServer) setServers(servers ...*api.Server) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.servers = servers
+}
+
+func (s *getServersServer) GetServers(ctx context.Context, req *api.GetServersRequest) (*api.GetServersResponse, error) {
+	s.mu.Lock()
+	blocked := s.blocked
+	s.mu.Unlock()
+	if blocked != nil {
+		select {
+		case <-blocked:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &api.GetServersResponse{Servers: s.servers}, nil
+}
+
+// clientConn implements resolver.ClientConn, recording state updates.
+type clientConn struct {
+	resolver.ClientConn
+	mu     sync.Mutex
+	states []resolver.State
+	errs   []error
+}
+
+func (c *clientConn) ReportError(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errs = append(c.errs, err)
+}
+
+func (c *clientConn) errors() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.errs)
+}
+
+func (c *clientConn) UpdateState(state resolver.State) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.states = append(c.states, state)
+	return nil
+}
+
+func (c *clientConn) ParseServiceConfig(config string) *serviceconfig.ParseResult {
+	return &serviceconfig.ParseResult{}
+}
+
+func (c *clientConn) updates() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.states)
+}
+
+func (c *clientConn) state() resolver.State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.states[len(c.states)-1]
+}
+
+// failingDiscovery never discovers servers.
+type failingDiscovery struct{}
+
+func (failingDiscovery) Discover(context.Context) ([]resolver.Address, error) {
+	return nil, loadbalance.ErrNoServers
+}
+
+func (failingDiscovery) Close() error {
+	return nil
+}
+
+// blockingDiscovery fails the discoveries once unblocked, counting them.
+type blockingDiscovery struct {
+	unblock chan struct{}
+	n       int64
+}
+
+func (d *blockingDiscovery) Discover(ctx context.Context) ([]resolver.Address, error) {
+	atomic.AddInt64(&d.n, 1)
+	select {
+	case <-d.unblock:
+		return nil, loadbalance.ErrNoServers
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (d *blockingDiscovery) calls() int {
+	return int(atomic.LoadInt64(&d.n))
+}
+
+func (d *blockingDiscovery) Close() error {
+	return nil
+}
+
+func setupTestServer(t *testing.T) (*getServersServer, string, func()) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &getServersServer{}
+	gsrv := grpc.NewServer()
+	api.RegisterGeoServer(gsrv, srv)
+	go func() {
+		_ = gsrv.Serve(l)
+	}()
+
+	return srv, l.Addr().String(), gsrv.Stop
+}
+
+func buildResolver(t *testing.T, builder *loadbalance.Resolver, addr string, cc *clientConn) func() {
+	t.Helper()
+
+	target := resolver.Target{}
+	target.URL.Scheme = loadbalance.GeoCQRSResolverName
+	target.URL.Host = addr
+	r, err := builder.Build(target, cc, resolver.BuildOptions{
+		DialCreds: insecure.NewCredentials(),
+	})
+	require.NoError(t, err)
+	return r.Close
+}
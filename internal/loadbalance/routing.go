@@ -0,0 +1,100 @@
+package loadbalance
+
+import (
+	"context"
+	"fmt"
+)
+
+// Route is the class of servers a method's requests are sent to.
+type Route string
+
+const (
+	// RouteLeader sends requests to the leader, e.g. writes.
+	RouteLeader Route = "leader"
+	// RouteFollower sends requests to the followers, e.g. reads.
+	RouteFollower Route = "follower"
+	// RouteCommon sends requests to any server, e.g. cluster metadata.
+	RouteCommon Route = "common"
+)
+
+// DefaultRoute routes methods missing from the routing table.
+const DefaultRoute = RouteLeader
+
+const geoServicePrefix = "/geo.v1.Geo/"
+
+// methodRoutes is the routing table of the geo service methods,
+// keyed on the full method name.
+var methodRoutes = map[string]Route{
+	geoServicePrefix + "AddGeoLocation":        RouteLeader,
+	geoServicePrefix + "AddGeoLocationLatLong": RouteLeader,
+	geoServicePrefix + "DeleteGeoLocation":     RouteLeader,
+	geoServicePrefix + "AddAddress":            RouteLeader,
+	geoServicePrefix + "UpdateAddress":         RouteLeader,
+	geoServicePrefix + "DeleteAddress":         RouteLeader,
+
+	geoServicePrefix + "GeoLocate":         RouteFollower,
+	geoServicePrefix + "GetGeoRoute":       RouteFollower,
+	geoServicePrefix + "GetAddressRoute":   RouteFollower,
+	geoServicePrefix + "GetGeoLocation":    RouteFollower,
+	geoServicePrefix + "GetGeoLocations":   RouteFollower,
+	geoServicePrefix + "GetAddress":        RouteFollower,
+	geoServicePrefix + "GetAddresses":      RouteFollower,
+	geoServicePrefix + "GetAddressesByIds": RouteFollower,
+
+	geoServicePrefix + "GetServers":      RouteCommon,
+	geoServicePrefix + "GetAddressTypes": RouteCommon,
+}
+
+// FullMethod returns the full geo service method name.
+func FullMethod(method string) string {
+	return geoServicePrefix + method
+}
+
+// MethodRoute returns the default route of a full method name, ok is
+// false for methods missing from the routing table.
+func MethodRoute(method string) (route Route, ok bool) {
+	route, ok = methodRoutes[method]
+	return route, ok
+}
+
+func (r Route) validate() error {
+	switch r {
+	case RouteLeader, RouteFollower, RouteCommon:
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrInvalidRoute, r)
+	}
+}
+
+type routeKey struct{}
+
+// WithRoute overrides the route of the requests made with ctx.
+func WithRoute(ctx context.Context, route Route) context.Context {
+	return context.WithValue(ctx, routeKey{}, route)
+}
+
+// RouteFromContext returns the route override set by WithRoute.
+func RouteFromContext(ctx context.Context) (Route, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	route, ok := ctx.Value(routeKey{}).(Route)
+	return route, ok
+}
+
+type serverKey struct{}
+
+// WithServer sends the requests made with ctx to the server addr,
+// overriding the route. Meant for debugging.
+func WithServer(ctx context.Context, addr string) context.Context {
+	return context.WithValue(ctx, serverKey{}, addr)
+}
+
+// ServerFromContext returns the server set by WithServer.
+func ServerFromContext(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+	addr, ok := ctx.Value(serverKey{}).(string)
+	return addr, ok
+}
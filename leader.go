@@ -0,0 +1,46 @@
+package geo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/comfforts/comff-geo-client/internal/loadbalance"
+)
+
+// leaderRetryInterval is the delay between write attempts while
+// waiting for a leader.
+const leaderRetryInterval = 50 * time.Millisecond
+
+// IsNoLeader reports whether the call failed with Unavailable because
+// no cluster leader is known or the leader is unreachable. Such writes
+// never reached a server, unlike the servers' own Unavailable errors.
+func IsNoLeader(err error) bool {
+	return errors.Is(err, loadbalance.ErrNoLeader)
+}
+
+// awaitLeader retries the write call failing with no leader, for up to
+// wait or until ctx is done. The failed attempts never reached a server,
+// so retrying them is safe.
+func awaitLeader[T any](ctx context.Context, wait time.Duration, call func() (T, error)) (T, error) {
+	resp, err := call()
+	if wait <= 0 || !IsNoLeader(err) {
+		return resp, err
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	ticker := time.NewTicker(leaderRetryInterval)
+	defer ticker.Stop()
+	for IsNoLeader(err) {
+		select {
+		case <-ctx.Done():
+			return resp, err
+		case <-timer.C:
+			return resp, err
+		case <-ticker.C:
+			resp, err = call()
+		}
+	}
+	return resp, err
+}
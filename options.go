@@ -0,0 +1,324 @@
+package geo
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/comfforts/logger"
+
+	"github.com/comfforts/comff-geo-client/internal/loadbalance"
+)
+
+// Option configures a geo client created with New.
+type Option func(*ClientOption)
+
+// ClusterServer is a geo cluster member, as listed for static and
+// file discovery.
+type ClusterServer = loadbalance.Server
+
+// DiscoveryBuilder creates the cluster membership discovery source.
+type DiscoveryBuilder = loadbalance.DiscoveryBuilder
+
+// Locality is the zone and region of the client or a server.
+type Locality = loadbalance.Locality
+
+// PickTrace describes the server pick for a call: method, route,
+// picked server and reason.
+type PickTrace = loadbalance.PickTrace
+
+// WithAddr sets the geo service address, either host:port or a full
+// target such as geo-cqrs://host:port.
+func WithAddr(addr string) Option {
+	return func(o *ClientOption) {
+		o.Addr = addr
+	}
+}
+
+// WithSeeds adds seed servers used to discover the geo cluster.
+// All seeds are asked for the cluster membership at once, the first
+// answer listing servers is used.
+func WithSeeds(seeds ...string) Option {
+	return func(o *ClientOption) {
+		o.Seeds = append(o.Seeds, seeds...)
+	}
+}
+
+// WithDiscovery sets the cluster membership discovery source,
+// instead of the GetServers RPC on the seeds.
+func WithDiscovery(discovery DiscoveryBuilder) Option {
+	return func(o *ClientOption) {
+		o.Discovery = discovery
+	}
+}
+
+// WithStaticDiscovery uses a fixed cluster membership.
+func WithStaticDiscovery(servers ...ClusterServer) Option {
+	return WithDiscovery(loadbalance.StaticDiscovery(servers...))
+}
+
+// WithFileDiscovery reads the cluster membership from a JSON or YAML
+// file, checked for changes every pollInterval.
+func WithFileDiscovery(file string, pollInterval time.Duration) Option {
+	return WithDiscovery(loadbalance.FileDiscovery(file, pollInterval))
+}
+
+// WithDNSDiscovery looks up the cluster membership from the DNS SRV
+// records of name. The single record with the lowest priority is the
+// leader, there is none when all priorities are equal.
+func WithDNSDiscovery(name string) Option {
+	return WithDiscovery(loadbalance.DNSDiscovery(name))
+}
+
+// WithReadYourWrites pins the client reads to the leader for window
+// after each write made by the client.
+func WithReadYourWrites(window time.Duration) Option {
+	return func(o *ClientOption) {
+		o.ReadYourWritesWindow = window
+	}
+}
+
+// WithRetry retries the reads failing with a retryable status, see
+// RetryPolicy for the defaults of the unset values. Writes are never
+// retried.
+func WithRetry(policy RetryPolicy) Option {
+	return func(o *ClientOption) {
+		o.Retry = &policy
+	}
+}
+
+// WithHedging hedges the read methods, by RPC name, GeoLocate,
+// GetAddress and GetAddressesByIds when none are given: when a read
+// hasn't answered within delay, e.g. its p95 latency, it's sent again
+// to another follower, and the first answer is used. Only reads can be
+// hedged. Calls capturing the header, trailer or peer aren't hedged.
+func WithHedging(delay time.Duration, methods ...string) Option {
+	return func(o *ClientOption) {
+		if len(methods) == 0 {
+			methods = defaultHedgedMethods
+		}
+		if o.HedgeDelays == nil {
+			o.HedgeDelays = make(map[string]time.Duration)
+		}
+		for _, method := range methods {
+			o.HedgeDelays[method] = delay
+		}
+	}
+}
+
+// WithHealthCheck checks the cluster servers health with the gRPC
+// health service for serviceName, e.g. geo.v1.Geo, or the overall server
+// health when empty. Unhealthy followers get no reads, and an unhealthy
+// leader no writes.
+func WithHealthCheck(serviceName string) Option {
+	return func(o *ClientOption) {
+		o.HealthCheck = true
+		o.HealthCheckService = serviceName
+	}
+}
+
+// WithLeaderWait retries the writes failing with no leader for up to
+// wait, while a new leader is elected, instead of failing them fast.
+func WithLeaderWait(wait time.Duration) Option {
+	return func(o *ClientOption) {
+		o.LeaderWait = wait
+	}
+}
+
+// WithLocality sets the client zone and region. Reads prefer the
+// followers in the same zone, then region, and fall back to the others
+// when none are ready. It doesn't override a balancer config locality.
+func WithLocality(zone, region string) Option {
+	return func(o *ClientOption) {
+		o.Locality = Locality{Zone: zone, Region: region}
+	}
+}
+
+// WithServerLocality assigns the zone and region of the server addr,
+// when its discovery doesn't provide them.
+func WithServerLocality(addr, zone, region string) Option {
+	return func(o *ClientOption) {
+		if o.ServerLocalities == nil {
+			o.ServerLocalities = make(map[string]Locality)
+		}
+		o.ServerLocalities[addr] = Locality{Zone: zone, Region: region}
+	}
+}
+
+// WithBalancerConfig sets the geo-cqrs balancer config JSON, with the
+// follower selection, readFromLeader, common and per method routes.
+func WithBalancerConfig(js string) Option {
+	return func(o *ClientOption) {
+		o.BalancerConfig = js
+	}
+}
+
+// WithEnv sets the geo service address from GEO_SERVICE_HOST and
+// GEO_SERVICE_PORT, using the defaults for any that are not set.
+func WithEnv() Option {
+	return func(o *ClientOption) {
+		servicePort := os.Getenv("GEO_SERVICE_PORT")
+		if servicePort == "" {
+			servicePort = DEFAULT_SERVICE_PORT
+		}
+		serviceHost := os.Getenv("GEO_SERVICE_HOST")
+		if serviceHost == "" {
+			serviceHost = DEFAULT_SERVICE_HOST
+		}
+		o.Addr = fmt.Sprintf("%s:%s", serviceHost, servicePort)
+	}
+}
+
+// WithLogger sets the client logger.
+func WithLogger(l logger.AppLogger) Option {
+	return func(o *ClientOption) {
+		o.Logger = l
+	}
+}
+
+// WithDiagnosticsLogger logs the resolver and balancer diagnostics,
+// with the picks at debug level.
+func WithDiagnosticsLogger(l *zap.Logger) Option {
+	return func(o *ClientOption) {
+		o.DiagnosticsLogger = l
+	}
+}
+
+// WithPickTrace calls fn with every pick decision, e.g. for debug
+// tooling. fn must not block.
+func WithPickTrace(fn func(PickTrace)) Option {
+	return func(o *ClientOption) {
+		o.OnPick = fn
+	}
+}
+
+// WithCaller sets the caller name sent as service-client metadata.
+func WithCaller(caller string) Option {
+	return func(o *ClientOption) {
+		o.Caller = caller
+	}
+}
+
+// WithTransportCredentials sets the connection credentials,
+// replacing the default TLS setup.
+func WithTransportCredentials(creds credentials.TransportCredentials) Option {
+	return func(o *ClientOption) {
+		o.Creds = creds
+	}
+}
+
+// WithDialOptions appends extra dial options for the connection.
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(o *ClientOption) {
+		o.DialOptions = append(o.DialOptions, opts...)
+	}
+}
+
+// WithDialTimeout sets the timeout of each server dial. It doesn't
+// change the reconnect backoff.
+func WithDialTimeout(d time.Duration) Option {
+	return func(o *ClientOption) {
+		o.DialTimeout = d
+	}
+}
+
+// WithCallOptions appends default call options applied to every RPC.
+func WithCallOptions(opts ...grpc.CallOption) Option {
+	return func(o *ClientOption) {
+		o.CallOptions = append(o.CallOptions, opts...)
+	}
+}
+
+// WithCallTimeout sets the default deadline for each RPC.
+func WithCallTimeout(d time.Duration) Option {
+	return func(o *ClientOption) {
+		o.CallTimeout = d
+	}
+}
+
+// WithMethodTimeout sets the deadline for an RPC method, e.g. GetGeoRoute.
+func WithMethodTimeout(method string, d time.Duration) Option {
+	return func(o *ClientOption) {
+		if o.MethodTimeouts == nil {
+			o.MethodTimeouts = map[string]time.Duration{}
+		}
+		o.MethodTimeouts[method] = d
+	}
+}
+
+// WithKeepAlive sets the client keepalive interval and timeout. The server
+// must permit pings at that rate through its keepalive.EnforcementPolicy.
+func WithKeepAlive(keepAlive, timeout time.Duration) Option {
+	return func(o *ClientOption) {
+		o.KeepAlive = keepAlive
+		o.KeepAliveTimeout = timeout
+	}
+}
+
+// WithPermitWithoutStream sets whether keepalive pings are sent
+// when there are no active RPCs. It's off by default since servers reject
+// such pings unless their enforcement policy sets PermitWithoutStream.
+func WithPermitWithoutStream(permit bool) Option {
+	return func(o *ClientOption) {
+		o.PermitWithoutStream = permit
+	}
+}
+
+// WithCA verifies the server certificate against the given PEM CA bundle.
+func WithCA(caFile string) Option {
+	return func(o *ClientOption) {
+		tlsOption(o).CAFile = caFile
+	}
+}
+
+// WithClientCert presents the given client certificate for mTLS.
+func WithClientCert(certFile, keyFile string) Option {
+	return func(o *ClientOption) {
+		t := tlsOption(o)
+		t.CertFile = certFile
+		t.KeyFile = keyFile
+	}
+}
+
+// WithServerName overrides the server name verified against the
+// server certificates, each server's host by default.
+func WithServerName(name string) Option {
+	return func(o *ClientOption) {
+		tlsOption(o).ServerName = name
+	}
+}
+
+// WithInsecureSkipVerify disables server certificate verification.
+// Only meant for local development.
+func WithInsecureSkipVerify() Option {
+	return func(o *ClientOption) {
+		tlsOption(o).Insecure = true
+	}
+}
+
+func tlsOption(o *ClientOption) *TLSOption {
+	if o.TLS == nil {
+		o.TLS = &TLSOption{}
+	}
+	return o.TLS
+}
+
+// WithRefresh sets the cluster membership refresh interval and jitter.
+// A zero interval disables the background refresh.
+func WithRefresh(interval, jitter time.Duration) Option {
+	return func(o *ClientOption) {
+		o.RefreshInterval = interval
+		o.RefreshJitter = jitter
+	}
+}
+
+// WithMembershipCache persists the last good cluster membership to file.
+func WithMembershipCache(file string) Option {
+	return func(o *ClientOption) {
+		o.MembershipCacheFile = file
+	}
+}
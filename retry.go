@@ -0,0 +1,168 @@
+package geo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/comfforts/comff-geo-client/internal/loadbalance"
+)
+
+var (
+	// ErrNotRetryable is returned for retry policies on methods other than reads.
+	ErrNotRetryable = errors.New("only reads can be retried")
+	// ErrInvalidBackoff is returned for retry backoff with negative delays
+	// or jitter, a multiplier below 1, or jitter above 1.
+	ErrInvalidBackoff = errors.New("invalid retry backoff")
+)
+
+const defaultRetryAttempts = 3
+
+// defaultRetryBackoff is the default delay between attempts.
+var defaultRetryBackoff = backoff.Config{
+	BaseDelay:  100 * time.Millisecond,
+	Multiplier: 2,
+	Jitter:     0.2,
+	MaxDelay:   time.Second,
+}
+
+// defaultRetryMethods are the reads retried by default.
+var defaultRetryMethods = []string{
+	"GeoLocate",
+	"GetGeoRoute",
+	"GetAddress",
+	"GetAddresses",
+	"GetAddressesByIds",
+	"GetGeoLocation",
+	"GetGeoLocations",
+	"GetServers",
+}
+
+// RetryPolicy retries the reads failing with a retryable status. The
+// retries go to another follower when possible, and aren't made past
+// the call deadline.
+type RetryPolicy struct {
+	// MaxAttempts is the call attempts, including the first, 3 by default.
+	MaxAttempts int
+	// Backoff is the delay between attempts, by default from 100ms
+	// doubling up to 1s, with 20% jitter. The unset delays and multiplier
+	// are defaulted each, MaxDelay to at least BaseDelay, the jitter only
+	// with the whole backoff unset.
+	Backoff backoff.Config
+	// Codes are the retryable status codes, Unavailable by default.
+	Codes []codes.Code
+	// Methods are the retried RPC names, e.g. GetAddress, by default the
+	// reads GeoLocate, GetGeoRoute, GetAddress, GetAddresses,
+	// GetAddressesByIds, GetGeoLocation, GetGeoLocations and GetServers.
+	Methods []string
+}
+
+// withDefaults returns the policy with the defaults for the unset
+// values, checking the backoff and that only reads are retried.
+func (p RetryPolicy) withDefaults() (*RetryPolicy, error) {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = defaultRetryAttempts
+	}
+	if p.Backoff == (backoff.Config{}) {
+		p.Backoff = defaultRetryBackoff
+	}
+	if p.Backoff.BaseDelay == 0 {
+		p.Backoff.BaseDelay = defaultRetryBackoff.BaseDelay
+	}
+	if p.Backoff.Multiplier == 0 {
+		p.Backoff.Multiplier = defaultRetryBackoff.Multiplier
+	}
+	if p.Backoff.MaxDelay == 0 {
+		p.Backoff.MaxDelay = defaultRetryBackoff.MaxDelay
+		if p.Backoff.MaxDelay < p.Backoff.BaseDelay {
+			p.Backoff.MaxDelay = p.Backoff.BaseDelay
+		}
+	}
+	if b := p.Backoff; b.BaseDelay < 0 || b.MaxDelay < 0 || b.Multiplier < 1 || b.Jitter < 0 || b.Jitter > 1 {
+		return nil, fmt.Errorf("%w: %+v", ErrInvalidBackoff, b)
+	}
+	if len(p.Codes) == 0 {
+		p.Codes = []codes.Code{codes.Unavailable}
+	}
+	if len(p.Methods) == 0 {
+		p.Methods = defaultRetryMethods
+	}
+	for _, method := range p.Methods {
+		route, ok := loadbalance.MethodRoute(loadbalance.FullMethod(method))
+		if !ok || route == loadbalance.RouteLeader {
+			return nil, fmt.Errorf("%w: %s", ErrNotRetryable, method)
+		}
+	}
+	return &p, nil
+}
+
+// retries reports whether the policy retries the method.
+func (p *RetryPolicy) retries(method string) bool {
+	if p == nil || p.MaxAttempts < 2 {
+		return false
+	}
+	for _, m := range p.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	code := status.Code(err)
+	for _, c := range p.Codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// retry makes the call, retrying the retryable failures with backoff,
+// up to the policy attempts and within the ctx deadline. The attempts
+// are tracked in ctx so the retries prefer other followers.
+func retry[T any](ctx context.Context, policy *RetryPolicy, call func(context.Context) (T, error)) (T, error) {
+	if loadbalance.AttemptsFromContext(ctx) == nil {
+		ctx = loadbalance.WithAttempts(ctx, &loadbalance.Attempts{})
+	}
+	resp, err := call(ctx)
+	for attempt := 1; attempt < policy.MaxAttempts && err != nil && policy.retryable(err); attempt++ {
+		delay := loadbalance.BackoffDelay(policy.Backoff, attempt-1)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+			return resp, err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return resp, err
+		case <-timer.C:
+		}
+		resp, err = call(ctx)
+	}
+	return resp, err
+}
+
+// read makes the read call, hedged and retried per the client config.
+func read[T any](
+	ctx context.Context,
+	gc *geoClient,
+	method string,
+	opts []grpc.CallOption,
+	call func(context.Context) (T, error),
+) (T, error) {
+	delay := gc.hedgeDelay(ctx, method, opts)
+	if !gc.opts.Retry.retries(method) {
+		return hedge(ctx, delay, call)
+	}
+	return retry(ctx, gc.opts.Retry, func(ctx context.Context) (T, error) {
+		return hedge(ctx, delay, call)
+	})
+}
@@ -0,0 +1,83 @@
+package geo
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+
+	"github.com/comfforts/comff-geo-client/internal/loadbalance"
+)
+
+// WithLeader sends the calls made with ctx to the leader, e.g. for
+// strongly consistent reads.
+func WithLeader(ctx context.Context) context.Context {
+	return loadbalance.WithRoute(ctx, loadbalance.RouteLeader)
+}
+
+// WithFollower sends the calls made with ctx to a follower.
+func WithFollower(ctx context.Context) context.Context {
+	return loadbalance.WithRoute(ctx, loadbalance.RouteFollower)
+}
+
+// WithServer sends the calls made with ctx to the server addr, as listed
+// by GetServers, whatever their route. Meant for debugging. While the
+// server isn't connected, calls fail with Unavailable, wait for ready
+// calls wait for it until their deadline.
+func WithServer(ctx context.Context, addr string) context.Context {
+	return loadbalance.WithServer(ctx, addr)
+}
+
+// routeCallOption overrides the routing of a single call.
+type routeCallOption struct {
+	grpc.EmptyCallOption
+	route loadbalance.Route
+	addr  string
+}
+
+// ToLeader is a CallOption sending the call to the leader.
+func ToLeader() grpc.CallOption {
+	return routeCallOption{route: loadbalance.RouteLeader}
+}
+
+// ToFollower is a CallOption sending the call to a follower.
+func ToFollower() grpc.CallOption {
+	return routeCallOption{route: loadbalance.RouteFollower}
+}
+
+// ToServer is a CallOption sending the call to the server addr, see WithServer.
+func ToServer(addr string) grpc.CallOption {
+	return routeCallOption{addr: addr}
+}
+
+// routeInterceptor moves the call routing options to the call context,
+// where the picker reads them.
+func routeInterceptor(
+	ctx context.Context,
+	method string,
+	req, reply interface{},
+	cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker,
+	opts ...grpc.CallOption,
+) error {
+	for _, opt := range opts {
+		o, ok := opt.(routeCallOption)
+		if !ok {
+			continue
+		}
+		if o.addr != "" {
+			ctx = loadbalance.WithServer(ctx, o.addr)
+		} else {
+			ctx = loadbalance.WithRoute(ctx, o.route)
+		}
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// routed reports whether the call routing is overridden in ctx.
+func routed(ctx context.Context) bool {
+	if _, ok := loadbalance.RouteFromContext(ctx); ok {
+		return true
+	}
+	_, ok := loadbalance.ServerFromContext(ctx)
+	return ok
+}
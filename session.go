@@ -0,0 +1,78 @@
+package geo
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/comfforts/comff-geo-client/internal/loadbalance"
+)
+
+// Session gives read-your-writes consistency: for a window after a
+// write made in the session, its reads are sent to the leader instead
+// of possibly stale followers.
+type Session struct {
+	window    time.Duration
+	mu        sync.Mutex
+	lastWrite time.Time
+}
+
+// NewSession creates a session pinning reads to the leader for
+// window after each write.
+func NewSession(window time.Duration) *Session {
+	return &Session{window: window}
+}
+
+type sessionKey struct{}
+
+// ContextWithSession makes the client calls with ctx part of the session.
+func ContextWithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+func sessionFromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
+
+func (s *Session) recordWrite() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastWrite = time.Now()
+}
+
+// pinned reports whether reads are pinned to the leader.
+func (s *Session) pinned() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.lastWrite.IsZero() && time.Since(s.lastWrite) < s.window
+}
+
+// session returns the context session, or the client session if any.
+func (gc *geoClient) session(ctx context.Context) *Session {
+	if s := sessionFromContext(ctx); s != nil {
+		return s
+	}
+	return gc.sess
+}
+
+// withSession routes the method to the leader while the session is
+// pinned, unless the call routing is overridden.
+func (gc *geoClient) withSession(ctx context.Context, method string) context.Context {
+	s := gc.session(ctx)
+	if s == nil || !s.pinned() || routed(ctx) {
+		return ctx
+	}
+	if route, ok := loadbalance.MethodRoute(loadbalance.FullMethod(method)); ok && route == loadbalance.RouteLeader {
+		return ctx
+	}
+	return loadbalance.WithRoute(ctx, loadbalance.RouteLeader)
+}
+
+// recordWrite pins the session reads to the leader after a write,
+// whether it succeeded or not, as a failed write may still be applied.
+func (gc *geoClient) recordWrite(ctx context.Context) {
+	if s := gc.session(ctx); s != nil {
+		s.recordWrite()
+	}
+}
@@ -0,0 +1,75 @@
+package geo
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	config "github.com/comfforts/comff-config"
+)
+
+var ErrInvalidCA = errors.New("no certificates found in CA file")
+
+// TLSOption configures server certificate verification and the
+// client certificate used for mTLS.
+type TLSOption struct {
+	// CAFile is the PEM CA bundle used to verify the server.
+	CAFile string
+	// CertFile and KeyFile are the client certificate for mTLS.
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name verified against the server
+	// certificates, instead of each cluster server's host.
+	ServerName string
+	// Insecure skips server certificate verification. For local dev only.
+	Insecure bool
+}
+
+// setupTLSConfig builds the client TLS config. Without explicit CA or
+// client cert files, the comff-config TLS setup is used as the base.
+func setupTLSConfig(addr string, opts *TLSOption) (*tls.Config, error) {
+	if opts == nil {
+		opts = &TLSOption{}
+	}
+
+	var tlsConfig *tls.Config
+	if opts.CAFile == "" && opts.CertFile == "" {
+		var err error
+		tlsConfig, err = config.SetupTLSConfig(&config.ConfigOpts{
+			Target: config.GEO_CLIENT,
+			Addr:   addr,
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	if opts.CAFile != "" {
+		b, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		ca := x509.NewCertPool()
+		if !ca.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCA, opts.CAFile)
+		}
+		tlsConfig.RootCAs = ca
+	}
+
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	// each server is verified against its own host, unless overridden
+	tlsConfig.ServerName = opts.ServerName
+	tlsConfig.InsecureSkipVerify = opts.Insecure
+	return tlsConfig, nil
+}
@@ -0,0 +1,207 @@
+package geo
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	geo_v1 "github.com/comfforts/comff-geo/api/v1"
+	"github.com/comfforts/logger"
+)
+
+func TestTLS(t *testing.T) {
+	logger := logger.NewTestAppLogger(TEST_DIR)
+	ca := newTestCA(t, "geo-test-ca")
+
+	getServers := func(t *testing.T, addr string, opts ...Option) error {
+		gc, err := New(append([]Option{WithAddr(addr), WithLogger(logger)}, opts...)...)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, gc.Close())
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err = gc.GetServers(ctx, &geo_v1.GetServersRequest{})
+		return err
+	}
+
+	t.Run("ca bundle verifies each server's host", func(t *testing.T) {
+		leader, teardown := setupTestServer(t, ca.serverCreds(t, "127.0.0.1"))
+		defer teardown()
+		follower, teardown := setupTestServer(t, ca.serverCreds(t, "localhost"))
+		defer teardown()
+		_, port, err := net.SplitHostPort(follower.Addr().String())
+		require.NoError(t, err)
+		followerAddr := net.JoinHostPort("localhost", port)
+		leader.srv.setFollowers(followerAddr)
+
+		gc, err := New(
+			WithAddr(leader.Addr().String()),
+			WithLogger(logger),
+			WithCA(ca.file),
+		)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, gc.Close())
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err = gc.AddAddress(ctx, &geo_v1.AddressRequest{}, grpc.WaitForReady(true))
+		require.Equal(t, codes.Unimplemented, status.Code(err))
+		_, err = gc.GetAddress(ctx, &geo_v1.GetAddressRequest{}, grpc.WaitForReady(true), ToServer(followerAddr))
+		require.NoError(t, err)
+
+		// untrusted server
+		require.Error(t, getServers(t, leader.Addr().String(), WithCA(newTestCA(t, "other-ca").file)))
+	})
+
+	t.Run("mtls", func(t *testing.T) {
+		lis, teardown := setupTestServer(t, ca.serverCreds(t, "127.0.0.1", ca.clientAuth()))
+		defer teardown()
+
+		certFile, keyFile := ca.issue(t, "geo-test-client")
+		require.NoError(t, getServers(t, lis.Addr().String(), WithCA(ca.file), WithClientCert(certFile, keyFile)))
+
+		// no client certificate
+		require.Error(t, getServers(t, lis.Addr().String(), WithCA(ca.file)))
+	})
+
+	t.Run("server name override", func(t *testing.T) {
+		lis, teardown := setupTestServer(t, ca.serverCreds(t, "geo.test"))
+		defer teardown()
+
+		require.NoError(t, getServers(t, lis.Addr().String(), WithCA(ca.file), WithServerName("geo.test")))
+
+		// server host doesn't match the certificate
+		require.Error(t, getServers(t, lis.Addr().String(), WithCA(ca.file)))
+	})
+
+	t.Run("insecure", func(t *testing.T) {
+		other := newTestCA(t, "other-ca")
+		lis, teardown := setupTestServer(t, other.serverCreds(t, "geo.test"))
+		defer teardown()
+
+		require.NoError(t, getServers(t, lis.Addr().String(), WithCA(ca.file), WithInsecureSkipVerify()))
+		require.Error(t, getServers(t, lis.Addr().String(), WithCA(ca.file)))
+	})
+}
+
+// testCA issues the test certificates, written as PEM files.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+	// file is the CA bundle.
+	file string
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	ca := &testCA{cert: cert, key: key, dir: t.TempDir()}
+	ca.file = filepath.Join(ca.dir, "ca.pem")
+	writePEM(t, ca.file, "CERTIFICATE", der)
+	return ca
+}
+
+// issue issues a certificate for the hosts, a client certificate
+// without hosts, returning its PEM cert and key files.
+func (ca *testCA) issue(t *testing.T, name string, hosts ...string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if len(hosts) > 0 {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	file := filepath.Join(ca.dir, strings.ReplaceAll(name, ".", "-"))
+	certFile, keyFile = file+".pem", file+"-key.pem"
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+// clientAuth requires client certificates issued by the CA.
+func (ca *testCA) clientAuth() func(*tls.Config) {
+	return func(c *tls.Config) {
+		c.ClientCAs = x509.NewCertPool()
+		c.ClientCAs.AddCert(ca.cert)
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+}
+
+// serverCreds serves a certificate issued for the host.
+func (ca *testCA) serverCreds(t *testing.T, host string, opts ...func(*tls.Config)) grpc.ServerOption {
+	t.Helper()
+
+	cert, err := tls.LoadX509KeyPair(ca.issue(t, host, host))
+	require.NoError(t, err)
+	c := &tls.Config{Certificates: []tls.Certificate{cert}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return grpc.Creds(credentials.NewTLS(c))
+}
+
+func writePEM(t *testing.T, file, blockType string, der []byte) {
+	t.Helper()
+
+	b := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	require.NoError(t, os.WriteFile(file, b, 0600))
+}